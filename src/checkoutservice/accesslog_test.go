@@ -0,0 +1,110 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+)
+
+type accessLogEntry struct {
+	Message    string  `json:"message"`
+	Method     string  `json:"method"`
+	Code       string  `json:"code"`
+	Peer       string  `json:"peer"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+func accessLogEntries(t *testing.T, logs string) []accessLogEntry {
+	t.Helper()
+	var out []accessLogEntry
+	for _, line := range strings.Split(logs, "\n") {
+		var e accessLogEntry
+		if line == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		if e.Message == "rpc" {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func TestAccessLog(t *testing.T) {
+	tests := []struct {
+		name         string
+		healthChecks bool
+		wantMethods  []string
+	}{
+		{"health checks excluded", false, []string{"/hipstershop.CheckoutService/PlaceOrder"}},
+		{"health checks included", true, []string{healthpb.Health_Check_FullMethodName, "/hipstershop.CheckoutService/PlaceOrder"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			lis := bufconn.Listen(1024 * 1024)
+			srv := grpc.NewServer(grpc.UnaryInterceptor(accessLog{healthChecks: tt.healthChecks}.unaryInterceptor))
+			pb.RegisterCheckoutServiceServer(srv, new(checkoutService))
+			healthpb.RegisterHealthServer(srv, healthpb.UnimplementedHealthServer{})
+			go srv.Serve(lis)
+			defer srv.Stop()
+
+			conn, err := grpc.DialContext(context.Background(), "bufnet",
+				grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+					return lis.DialContext(ctx)
+				}),
+				grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatalf("failed to dial bufnet: %v", err)
+			}
+			defer conn.Close()
+			healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+			// No currency and no default currency: rejected as InvalidArgument.
+			pb.NewCheckoutServiceClient(conn).PlaceOrder(context.Background(), &pb.PlaceOrderRequest{})
+
+			entries := accessLogEntries(t, logs.String())
+			if len(entries) != len(tt.wantMethods) {
+				t.Fatalf("got %d access log entries %+v, want %d", len(entries), entries, len(tt.wantMethods))
+			}
+			for i, e := range entries {
+				if e.Method != tt.wantMethods[i] {
+					t.Errorf("entry %d method = %q, want %q", i, e.Method, tt.wantMethods[i])
+				}
+				if e.Peer == "" || e.DurationMS < 0 {
+					t.Errorf("entry %d = %+v, want a peer and a duration", i, e)
+				}
+			}
+			if last := entries[len(entries)-1]; last.Code != "InvalidArgument" {
+				t.Errorf("PlaceOrder code = %q, want InvalidArgument", last.Code)
+			}
+			if tt.healthChecks && entries[0].Code != "Unimplemented" {
+				t.Errorf("Check code = %q, want Unimplemented", entries[0].Code)
+			}
+		})
+	}
+}
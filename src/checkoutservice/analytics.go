@@ -0,0 +1,88 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+)
+
+// analyticsTimeout bounds each analytics report so that a slow endpoint
+// can't pile up goroutines.
+const analyticsTimeout = 5 * time.Second
+
+// orderAnalytics is the payload posted to the analytics endpoint for every
+// placed order.
+type orderAnalytics struct {
+	OrderID    string   `json:"order_id"`
+	ProductIDs []string `json:"product_ids"`
+}
+
+// analyticsReporter posts the products of placed orders to an analytics
+// endpoint, so that the catalog can improve its recommendations.
+type analyticsReporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+func newAnalyticsReporter(endpoint string) *analyticsReporter {
+	return &analyticsReporter{
+		endpoint: endpoint,
+		client:   newExternalHTTPClient(analyticsTimeout),
+	}
+}
+
+// reportOrder posts the product ids of order in the background. It never
+// blocks the caller; failures are logged and otherwise ignored.
+func (a *analyticsReporter) reportOrder(order *pb.OrderResult) {
+	payload := orderAnalytics{OrderID: order.GetOrderId()}
+	for _, item := range order.GetItems() {
+		payload.ProductIDs = append(payload.ProductIDs, item.GetItem().GetProductId())
+	}
+	go func() {
+		if err := a.post(payload); err != nil {
+			log.Warnf("failed to report order %s to analytics: %v", payload.OrderID, err)
+		}
+	}()
+}
+
+func (a *analyticsReporter) post(payload orderAnalytics) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), analyticsTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
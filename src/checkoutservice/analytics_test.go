@@ -0,0 +1,59 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPlaceOrderAnalyticsFailureIgnored(t *testing.T) {
+	payloads := make(chan orderAnalytics, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p orderAnalytics
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("invalid analytics payload: %v", err)
+		}
+		payloads <- p
+		http.Error(w, "analytics is down", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	f := newFakeDownstreams()
+	cs := newTestCheckoutService(t, f)
+	cs.analytics = newAnalyticsReporter(srv.URL)
+
+	resp, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest())
+	if err != nil {
+		t.Fatalf("PlaceOrder() error = %v", err)
+	}
+
+	select {
+	case p := <-payloads:
+		if p.OrderID != resp.GetOrder().GetOrderId() {
+			t.Errorf("analytics order_id = %q, want %q", p.OrderID, resp.GetOrder().GetOrderId())
+		}
+		if want := []string{"OLJCESPC7Z", "66VCHSJNUP"}; !reflect.DeepEqual(p.ProductIDs, want) {
+			t.Errorf("analytics product_ids = %v, want %v", p.ProductIDs, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("analytics endpoint was not called")
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+)
+
+// auditRecord is one line of the order audit log.
+type auditRecord struct {
+	OrderID   string      `json:"order_id"`
+	UserID    string      `json:"user_id"`
+	Total     auditAmount `json:"total"`
+	Currency  string      `json:"currency"`
+	ItemCount int32       `json:"item_count"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+type auditAmount struct {
+	Units int64 `json:"units"`
+	Nanos int32 `json:"nanos"`
+}
+
+// orderAuditor appends a JSON line for every placed order to an append-only
+// file, kept apart from the operational logs.
+type orderAuditor struct {
+	now func() time.Time
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newOrderAuditor(path string) (*orderAuditor, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &orderAuditor{now: time.Now, file: f}, nil
+}
+
+// record appends order, placed by userID for total, to the audit log and
+// flushes it to disk. ItemCount is the total quantity of the order's items.
+func (a *orderAuditor) record(userID string, order *pb.OrderResult, total pb.Money) error {
+	rec := auditRecord{
+		OrderID:   order.GetOrderId(),
+		UserID:    userID,
+		Total:     auditAmount{Units: total.GetUnits(), Nanos: total.GetNanos()},
+		Currency:  total.GetCurrencyCode(),
+		Timestamp: a.now().UTC(),
+	}
+	for _, item := range order.GetItems() {
+		rec.ItemCount += item.GetItem().GetQuantity()
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.file.Write(line); err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+func (a *orderAuditor) close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
@@ -0,0 +1,81 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPlaceOrderAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.jsonl")
+	auditor, err := newOrderAuditor(path)
+	if err != nil {
+		t.Fatalf("newOrderAuditor() error = %v", err)
+	}
+	defer auditor.close()
+	placedAt := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	auditor.now = func() time.Time { return placedAt }
+
+	f := newFakeDownstreams()
+	cs := newTestCheckoutService(t, f)
+	cs.auditor = auditor
+
+	var orderIDs []string
+	for i := 0; i < 2; i++ {
+		f.cart = newFakeDownstreams().cart
+		resp, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest())
+		if err != nil {
+			t.Fatalf("PlaceOrder() error = %v", err)
+		}
+		orderIDs = append(orderIDs, resp.GetOrder().GetOrderId())
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var records []auditRecord
+	sc := bufio.NewScanner(file)
+	for sc.Scan() {
+		var rec auditRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("invalid audit line %q: %v", sc.Text(), err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 2 {
+		t.Fatalf("audit log has %d lines, want 2", len(records))
+	}
+	for i, rec := range records {
+		want := auditRecord{
+			OrderID:   orderIDs[i],
+			UserID:    "user-1",
+			Total:     auditAmount{Units: 54, Nanos: 470000000},
+			Currency:  "USD",
+			ItemCount: 3,
+			Timestamp: placedAt,
+		}
+		if rec != want {
+			t.Errorf("audit line %d = %+v, want %+v", i, rec, want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+)
+
+// duplicateItems decides what happens to a cart listing the same product in
+// more than one line item.
+type duplicateItems int
+
+const (
+	// duplicatesAllow prices each line item on its own.
+	duplicatesAllow duplicateItems = iota
+	// duplicatesMerge combines the line items of a product into one,
+	// summing the quantities.
+	duplicatesMerge
+	// duplicatesReject fails the order.
+	duplicatesReject
+)
+
+// duplicateItemsFromEnv reads DUPLICATE_CART_ITEMS, which is either unset,
+// "merge" or "reject".
+func duplicateItemsFromEnv() duplicateItems {
+	switch v := stringFromEnv("DUPLICATE_CART_ITEMS", ""); v {
+	case "":
+		return duplicatesAllow
+	case "merge":
+		return duplicatesMerge
+	case "reject":
+		return duplicatesReject
+	default:
+		panic(fmt.Sprintf("environment variable \"DUPLICATE_CART_ITEMS\" is %q, want \"merge\" or \"reject\"", v))
+	}
+}
+
+// mergeDuplicateItems returns items with the line items of each product
+// combined into the first one, and the id of the first product listed more
+// than once, if any. items is not modified.
+func mergeDuplicateItems(items []*pb.CartItem) ([]*pb.CartItem, string) {
+	var duplicate string
+	out := make([]*pb.CartItem, 0, len(items))
+	seen := make(map[string]*pb.CartItem, len(items))
+	for _, item := range items {
+		if merged, ok := seen[item.GetProductId()]; ok {
+			merged.Quantity += item.GetQuantity()
+			if duplicate == "" {
+				duplicate = item.GetProductId()
+			}
+			continue
+		}
+		merged := &pb.CartItem{ProductId: item.GetProductId(), Quantity: item.GetQuantity()}
+		seen[item.GetProductId()] = merged
+		out = append(out, merged)
+	}
+	return out, duplicate
+}
+
+// resolveDuplicateItems applies the configured duplicateItems mode to the
+// cart.
+func (cs *checkoutService) resolveDuplicateItems(items []*pb.CartItem) ([]*pb.CartItem, error) {
+	if cs.duplicateItems == duplicatesAllow {
+		return items, nil
+	}
+	merged, duplicate := mergeDuplicateItems(items)
+	if duplicate == "" {
+		return items, nil
+	}
+	if cs.duplicateItems == duplicatesReject {
+		return nil, orderError(codes.InvalidArgument, reasonDuplicateCartItem, "cart lists product %q more than once", duplicate)
+	}
+	return merged, nil
+}
+
+// fallbackCart is checked out in place of a user's cart while the cart
+// service is unavailable, so that demos can run without one. Its items are
+// never modified after construction.
+type fallbackCart struct {
+	items []*pb.CartItem
+}
+
+// fallbackCartFromEnv returns nil unless CART_FALLBACK_ENABLED=1. The cart
+// is read from CART_FALLBACK_ITEMS, a comma-separated list of
+// product_id=quantity entries.
+func fallbackCartFromEnv() *fallbackCart {
+	if !boolFromEnv("CART_FALLBACK_ENABLED") {
+		return nil
+	}
+	var items []*pb.CartItem
+	for _, kv := range listFromEnv("CART_FALLBACK_ITEMS") {
+		id, v, ok := strings.Cut(kv, "=")
+		qty, err := strconv.ParseInt(strings.TrimSpace(v), 10, 32)
+		if !ok || strings.TrimSpace(id) == "" || err != nil || qty < 1 {
+			panic(fmt.Sprintf("environment variable \"CART_FALLBACK_ITEMS\" has invalid entry %q, want product_id=quantity", kv))
+		}
+		items = append(items, &pb.CartItem{ProductId: strings.TrimSpace(id), Quantity: int32(qty)})
+	}
+	if len(items) == 0 {
+		panic("environment variable \"CART_FALLBACK_ITEMS\" must list at least one item when CART_FALLBACK_ENABLED=1")
+	}
+	return &fallbackCart{items: items}
+}
+
+// cartItems returns a copy of the fallback cart.
+func (c *fallbackCart) cartItems() []*pb.CartItem {
+	out := make([]*pb.CartItem, len(c.items))
+	for i, item := range c.items {
+		out[i] = &pb.CartItem{ProductId: item.GetProductId(), Quantity: item.GetQuantity()}
+	}
+	return out
+}
+
+// cartForOrder returns the cart of userID, or the fallback cart when one is
+// configured and the cart service is unavailable.
+func (cs *checkoutService) cartForOrder(ctx context.Context, userID string) ([]*pb.CartItem, error) {
+	items, err := cs.getUserCart(ctx, userID)
+	if err == nil || cs.fallbackCart == nil {
+		return items, err
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		log.WithField("user_id", userID).Warnf("cart service unavailable, checking out the fallback cart: %v", err)
+		return cs.fallbackCart.cartItems(), nil
+	}
+	return nil, err
+}
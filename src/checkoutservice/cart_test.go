@@ -0,0 +1,135 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+	money "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/money"
+)
+
+func duplicateCart() []*pb.CartItem {
+	return []*pb.CartItem{
+		{ProductId: "OLJCESPC7Z", Quantity: 2},
+		{ProductId: "66VCHSJNUP", Quantity: 1},
+		{ProductId: "OLJCESPC7Z", Quantity: 1},
+	}
+}
+
+func TestPlaceOrderDuplicateItemsMerge(t *testing.T) {
+	f := newFakeDownstreams()
+	f.cart = duplicateCart()
+	cs := newTestCheckoutService(t, f)
+	cs.duplicateItems = duplicatesMerge
+
+	resp, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest())
+	if err != nil {
+		t.Fatalf("PlaceOrder() error = %v", err)
+	}
+	items := resp.GetOrder().GetItems()
+	if len(items) != 2 || items[0].GetItem().GetProductId() != "OLJCESPC7Z" || items[0].GetItem().GetQuantity() != 3 {
+		t.Errorf("order items = %v, want OLJCESPC7Z x3 then 66VCHSJNUP x1", items)
+	}
+	// 3 x 19.99 + 5.50 + 8.99 shipping, the same as without merging.
+	want := pb.Money{CurrencyCode: "USD", Units: 74, Nanos: 460000000}
+	if len(f.charged) != 1 || !money.AreEquals(*f.charged[0], want) {
+		t.Errorf("charged %v, want %s", f.charged, money.Format(want))
+	}
+	if len(f.shipped) != 1 || len(f.shipped[0]) != 2 {
+		t.Errorf("shipped %v, want the two merged items", f.shipped)
+	}
+}
+
+func TestPlaceOrderDuplicateItemsReject(t *testing.T) {
+	f := newFakeDownstreams()
+	f.cart = duplicateCart()
+	cs := newTestCheckoutService(t, f)
+	cs.duplicateItems = duplicatesReject
+
+	_, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest())
+	if status.Code(err) != codes.InvalidArgument || failureLabel(err) != "validation" {
+		t.Errorf("PlaceOrder() error = %v, want an InvalidArgument duplicate item error", err)
+	}
+	if f.chargeCalls != 0 {
+		t.Errorf("Charge called %d times for a rejected cart, want 0", f.chargeCalls)
+	}
+}
+
+func TestPlaceOrderDuplicateItemsRejectUniqueCart(t *testing.T) {
+	f := newFakeDownstreams()
+	cs := newTestCheckoutService(t, f)
+	cs.duplicateItems = duplicatesReject
+
+	if _, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest()); err != nil {
+		t.Errorf("PlaceOrder() error = %v", err)
+	}
+}
+
+func TestMergeDuplicateItemsKeepsInput(t *testing.T) {
+	items := duplicateCart()
+	merged, duplicate := mergeDuplicateItems(items)
+	if duplicate != "OLJCESPC7Z" || len(merged) != 2 {
+		t.Errorf("mergeDuplicateItems() = %v, %q", merged, duplicate)
+	}
+	if items[0].GetQuantity() != 2 {
+		t.Errorf("input item quantity changed to %d", items[0].GetQuantity())
+	}
+}
+
+func TestPlaceOrderFallbackCart(t *testing.T) {
+	t.Setenv("CART_FALLBACK_ENABLED", "1")
+	t.Setenv("CART_FALLBACK_ITEMS", "66VCHSJNUP=3")
+	f := newFakeDownstreams()
+	f.getCartErr = status.Error(codes.Unavailable, "cart service is down")
+	cs := newTestCheckoutService(t, f)
+	cs.fallbackCart = fallbackCartFromEnv()
+
+	resp, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest())
+	if err != nil {
+		t.Fatalf("PlaceOrder() error = %v", err)
+	}
+	items := resp.GetOrder().GetItems()
+	if len(items) != 1 || items[0].GetItem().GetProductId() != "66VCHSJNUP" || items[0].GetItem().GetQuantity() != 3 {
+		t.Errorf("order items = %v, want the fallback cart", items)
+	}
+
+	f.getCartErr = status.Error(codes.PermissionDenied, "not your cart")
+	if _, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest()); failureLabel(err) != "cart" {
+		t.Errorf("PlaceOrder() error = %v, want a cart failure that the fallback doesn't mask", err)
+	}
+}
+
+func TestFallbackCartDisabledByDefault(t *testing.T) {
+	t.Setenv("CART_FALLBACK_ENABLED", "")
+	t.Setenv("CART_FALLBACK_ITEMS", "66VCHSJNUP=3")
+	if c := fallbackCartFromEnv(); c != nil {
+		t.Fatalf("fallbackCartFromEnv() = %v, want nil", c)
+	}
+
+	f := newFakeDownstreams()
+	f.getCartErr = status.Error(codes.Unavailable, "cart service is down")
+	cs := newTestCheckoutService(t, f)
+	if _, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest()); status.Code(err) != codes.Unavailable || failureLabel(err) != "cart" {
+		t.Errorf("PlaceOrder() error = %v, want an Unavailable cart failure", err)
+	}
+	if f.chargeCalls != 0 {
+		t.Errorf("Charge called %d times without a cart, want 0", f.chargeCalls)
+	}
+}
@@ -0,0 +1,815 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+	money "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/money"
+)
+
+// fakeDownstreams implements every service checkoutservice depends on so
+// that PlaceOrder can be exercised in-process over bufconn.
+type fakeDownstreams struct {
+	mu sync.Mutex
+
+	cart     []*pb.CartItem
+	products map[string]*pb.Product
+	quoteUSD *pb.Money
+	// rates maps a target currency to the value of one USD in it. Other
+	// conversions use a 1:1 rate.
+	rates map[string]pb.Money
+	// convertCode, when set, labels 1:1 conversion results with it instead
+	// of the requested currency.
+	convertCode string
+
+	getCartErr   error
+	emptyCartErr error
+	productErr   error
+	listErr      error
+	convertErr   error
+	quoteErr     error
+	chargeErr    error
+	shipErr      error
+	emailErr     error
+
+	// emptyCartFailures makes that many EmptyCart calls fail with
+	// Unavailable before emptyCartErr applies.
+	emptyCartFailures int
+	// emailFailures does the same for SendOrderConfirmation, and
+	// convertFailures for Convert.
+	emailFailures   int
+	convertFailures int
+	// emailDelay holds every SendOrderConfirmation for that long, or until
+	// the call is cancelled.
+	emailDelay time.Duration
+
+	// onGetCart, when set, runs after every GetCart with the lock held.
+	onGetCart func()
+
+	emptied     int
+	emptyCalls  int
+	emailCalls  int
+	chargeCalls int
+	listCalls   int
+	quoteCalls  int
+	getCalls    int
+	charged     []*pb.Money
+	cards       []*pb.CreditCardInfo
+	shipped     [][]*pb.CartItem
+	conversions int
+	emails      []*pb.SendOrderConfirmationRequest
+	emailMD     []metadata.MD
+}
+
+func newFakeDownstreams() *fakeDownstreams {
+	return &fakeDownstreams{
+		cart: []*pb.CartItem{
+			{ProductId: "OLJCESPC7Z", Quantity: 2},
+			{ProductId: "66VCHSJNUP", Quantity: 1},
+		},
+		products: map[string]*pb.Product{
+			"OLJCESPC7Z": {Id: "OLJCESPC7Z", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 19, Nanos: 990000000}},
+			"66VCHSJNUP": {Id: "66VCHSJNUP", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 5, Nanos: 500000000}},
+		},
+		quoteUSD: &pb.Money{CurrencyCode: "USD", Units: 8, Nanos: 990000000},
+	}
+}
+
+func (f *fakeDownstreams) AddItem(context.Context, *pb.AddItemRequest) (*pb.Empty, error) {
+	return &pb.Empty{}, nil
+}
+
+func (f *fakeDownstreams) GetCart(context.Context, *pb.GetCartRequest) (*pb.Cart, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	err := f.getCartErr
+	if f.onGetCart != nil {
+		f.onGetCart()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Cart{Items: f.cart}, nil
+}
+
+func (f *fakeDownstreams) EmptyCart(context.Context, *pb.EmptyCartRequest) (*pb.Empty, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.emptyCalls++
+	if f.emptyCartFailures > 0 {
+		f.emptyCartFailures--
+		return nil, status.Error(codes.Unavailable, "cart service restarting")
+	}
+	if f.emptyCartErr != nil {
+		return nil, f.emptyCartErr
+	}
+	f.emptied++
+	f.cart = nil
+	return &pb.Empty{}, nil
+}
+
+func (f *fakeDownstreams) ListProducts(context.Context, *pb.Empty) (*pb.ListProductsResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.listCalls++
+	if f.listErr != nil {
+		return nil, f.listErr
+	}
+	out := new(pb.ListProductsResponse)
+	for _, p := range f.products {
+		out.Products = append(out.Products, p)
+	}
+	return out, nil
+}
+
+func (f *fakeDownstreams) GetProduct(_ context.Context, req *pb.GetProductRequest) (*pb.Product, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.getCalls++
+	if f.productErr != nil {
+		return nil, f.productErr
+	}
+	p, ok := f.products[req.GetId()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no product with ID %s", req.GetId())
+	}
+	return p, nil
+}
+
+func (f *fakeDownstreams) SearchProducts(context.Context, *pb.SearchProductsRequest) (*pb.SearchProductsResponse, error) {
+	return &pb.SearchProductsResponse{}, nil
+}
+
+func (f *fakeDownstreams) GetSupportedCurrencies(context.Context, *pb.Empty) (*pb.GetSupportedCurrenciesResponse, error) {
+	return &pb.GetSupportedCurrenciesResponse{CurrencyCodes: []string{"USD", "EUR"}}, nil
+}
+
+func (f *fakeDownstreams) Convert(_ context.Context, req *pb.CurrencyConversionRequest) (*pb.Money, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.conversions++
+	if f.convertFailures > 0 {
+		f.convertFailures--
+		return nil, status.Error(codes.Unavailable, "currency service restarting")
+	}
+	if f.convertErr != nil {
+		return nil, f.convertErr
+	}
+	if rate, ok := f.rates[req.GetToCode()]; ok && req.GetFrom().GetCurrencyCode() == "USD" {
+		out, err := money.MultiplyRate(*req.GetFrom(), rate)
+		return &out, err
+	}
+	code := req.GetToCode()
+	if f.convertCode != "" {
+		code = f.convertCode
+	}
+	return &pb.Money{
+		CurrencyCode: code,
+		Units:        req.GetFrom().GetUnits(),
+		Nanos:        req.GetFrom().GetNanos()}, nil
+}
+
+func (f *fakeDownstreams) GetQuote(context.Context, *pb.GetQuoteRequest) (*pb.GetQuoteResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.quoteCalls++
+	if f.quoteErr != nil {
+		return nil, f.quoteErr
+	}
+	return &pb.GetQuoteResponse{CostUsd: f.quoteUSD}, nil
+}
+
+func (f *fakeDownstreams) ShipOrder(_ context.Context, req *pb.ShipOrderRequest) (*pb.ShipOrderResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.shipErr != nil {
+		return nil, f.shipErr
+	}
+	f.shipped = append(f.shipped, req.GetItems())
+	return &pb.ShipOrderResponse{TrackingId: "TRACK-1"}, nil
+}
+
+func (f *fakeDownstreams) Charge(_ context.Context, req *pb.ChargeRequest) (*pb.ChargeResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.chargeCalls++
+	if f.chargeErr != nil {
+		return nil, f.chargeErr
+	}
+	f.charged = append(f.charged, req.GetAmount())
+	f.cards = append(f.cards, req.GetCreditCard())
+	return &pb.ChargeResponse{TransactionId: "TX-1"}, nil
+}
+
+func (f *fakeDownstreams) SendOrderConfirmation(ctx context.Context, req *pb.SendOrderConfirmationRequest) (*pb.Empty, error) {
+	f.mu.Lock()
+	delay := f.emailDelay
+	f.mu.Unlock()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(delay):
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.emailCalls++
+	if f.emailFailures > 0 {
+		f.emailFailures--
+		return nil, status.Error(codes.Unavailable, "email service restarting")
+	}
+	if f.emailErr != nil {
+		return nil, f.emailErr
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	f.emails = append(f.emails, req)
+	f.emailMD = append(f.emailMD, md)
+	return &pb.Empty{}, nil
+}
+
+// newTestCheckoutService serves each of f's services on its own bufconn
+// listener and returns a checkoutService wired to them.
+func newTestCheckoutService(t *testing.T, f *fakeDownstreams) *checkoutService {
+	t.Helper()
+	return &checkoutService{
+		productCatalogSvcConn: serveBufconn(t, func(s *grpc.Server) { pb.RegisterProductCatalogServiceServer(s, f) }),
+		cartSvcConn:           serveBufconn(t, func(s *grpc.Server) { pb.RegisterCartServiceServer(s, f) }),
+		currencySvcConn:       serveBufconn(t, func(s *grpc.Server) { pb.RegisterCurrencyServiceServer(s, f) }),
+		shippingSvcConn:       serveBufconn(t, func(s *grpc.Server) { pb.RegisterShippingServiceServer(s, f) }),
+		emailSvcConn:          serveBufconn(t, func(s *grpc.Server) { pb.RegisterEmailServiceServer(s, f) }),
+		paymentSvcConn:        serveBufconn(t, func(s *grpc.Server) { pb.RegisterPaymentServiceServer(s, f) }),
+	}
+}
+
+// serveBufconn starts an in-process gRPC server set up by register and
+// returns a client connection to it. Both are closed when the test ends.
+func serveBufconn(t *testing.T, register func(*grpc.Server)) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer()
+	register(srv)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial bufnet: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// captureLogs redirects the service logger into a buffer for the duration of
+// the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	out := log.Out
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(out) })
+	return &buf
+}
+
+// logEntries decodes the JSON log lines in buf whose message matches msg.
+func logEntries(t *testing.T, buf *bytes.Buffer, msg string) []map[string]interface{} {
+	t.Helper()
+	var out []map[string]interface{}
+	for _, line := range bytes.Split(buf.Bytes(), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var e map[string]interface{}
+		if err := json.Unmarshal(line, &e); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		if e["message"] == msg {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func testPlaceOrderRequest() *pb.PlaceOrderRequest {
+	return &pb.PlaceOrderRequest{
+		UserId:       "user-1",
+		UserCurrency: "USD",
+		Email:        "someone@example.com",
+		Address: &pb.Address{
+			StreetAddress: "1600 Amphitheatre Parkway",
+			City:          "Mountain View",
+			State:         "CA",
+			Country:       "United States",
+			ZipCode:       94043,
+		},
+		CreditCard: &pb.CreditCardInfo{
+			CreditCardNumber:          "4432-8015-6152-0454",
+			CreditCardCvv:             672,
+			CreditCardExpirationYear:  2039,
+			CreditCardExpirationMonth: 1,
+		},
+	}
+}
+
+func TestPlaceOrderMissingSubMessages(t *testing.T) {
+	tests := []struct {
+		name  string
+		strip func(req *pb.PlaceOrderRequest)
+	}{
+		{"address", func(req *pb.PlaceOrderRequest) { req.Address = nil }},
+		{"credit card", func(req *pb.PlaceOrderRequest) { req.CreditCard = nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeDownstreams()
+			cs := newTestCheckoutService(t, f)
+
+			req := testPlaceOrderRequest()
+			tt.strip(req)
+			_, err := cs.PlaceOrder(context.Background(), req)
+			if status.Code(err) != codes.InvalidArgument || !strings.Contains(status.Convert(err).Message(), tt.name) {
+				t.Errorf("PlaceOrder() error = %v, want an InvalidArgument naming the %s", err, tt.name)
+			}
+			if f.chargeCalls != 0 {
+				t.Errorf("Charge called %d times for an invalid request, want 0", f.chargeCalls)
+			}
+		})
+	}
+}
+
+func TestPlaceOrderErrorDetails(t *testing.T) {
+	downstreamErr := status.Error(codes.Unavailable, "downstream is down")
+	tests := []struct {
+		name   string
+		setup  func(f *fakeDownstreams)
+		code   codes.Code
+		reason string
+	}{
+		{"cart", func(f *fakeDownstreams) { f.getCartErr = downstreamErr }, codes.Unavailable, reasonCartUnavailable},
+		{"product", func(f *fakeDownstreams) { f.productErr = downstreamErr }, codes.Unavailable, reasonProductUnavailable},
+		{"currency", func(f *fakeDownstreams) { f.convertErr = downstreamErr }, codes.Unavailable, reasonCurrencyUnavailable},
+		{"shipping quote", func(f *fakeDownstreams) { f.quoteErr = downstreamErr }, codes.Unavailable, reasonShippingQuoteFailed},
+		{"unknown product", func(f *fakeDownstreams) { delete(f.products, "66VCHSJNUP") }, codes.NotFound, reasonProductNotFound},
+		{"product without price", func(f *fakeDownstreams) { f.products["66VCHSJNUP"].PriceUsd = nil }, codes.Unavailable, reasonProductUnavailable},
+		{"shipping quote without cost", func(f *fakeDownstreams) { f.quoteUSD = nil }, codes.Unavailable, reasonShippingQuoteFailed},
+		{"payment", func(f *fakeDownstreams) { f.chargeErr = status.Error(codes.InvalidArgument, "card expired") }, codes.FailedPrecondition, reasonPaymentDeclined},
+		{"payment unavailable", func(f *fakeDownstreams) { f.chargeErr = downstreamErr }, codes.Unavailable, reasonPaymentUnavailable},
+		{"payment timeout", func(f *fakeDownstreams) { f.chargeErr = status.Error(codes.DeadlineExceeded, "timed out") }, codes.Unavailable, reasonPaymentUnavailable},
+		{"shipping", func(f *fakeDownstreams) { f.shipErr = downstreamErr }, codes.Unavailable, reasonShippingUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeDownstreams()
+			tt.setup(f)
+			cs := newTestCheckoutService(t, f)
+
+			// Order in a currency other than the catalog's so that the
+			// currency service is called.
+			req := testPlaceOrderRequest()
+			req.UserCurrency = "EUR"
+			_, err := cs.PlaceOrder(context.Background(), req)
+			st, ok := status.FromError(err)
+			if !ok {
+				t.Fatalf("PlaceOrder() error %v is not a gRPC status", err)
+			}
+			if st.Code() != tt.code {
+				t.Errorf("PlaceOrder() code = %v, want %v", st.Code(), tt.code)
+			}
+			var info *errdetails.ErrorInfo
+			for _, d := range st.Details() {
+				if ei, ok := d.(*errdetails.ErrorInfo); ok {
+					info = ei
+				}
+			}
+			if info == nil {
+				t.Fatalf("PlaceOrder() error has no ErrorInfo detail: %v", st.Details())
+			}
+			if info.GetReason() != tt.reason || info.GetDomain() != errorDomain {
+				t.Errorf("ErrorInfo = %s/%s, want %s/%s", info.GetDomain(), info.GetReason(), errorDomain, tt.reason)
+			}
+		})
+	}
+}
+
+func TestOrderErrorKeepsMessage(t *testing.T) {
+	err := orderError(codes.Unavailable, reasonCartUnavailable, "cart failure: %v", errors.New("boom"))
+	if got := status.Convert(err).Message(); got != "cart failure: boom" {
+		t.Errorf("message = %q, want %q", got, "cart failure: boom")
+	}
+}
+
+func TestDialGRPCTimeout(t *testing.T) {
+	// Nothing listens on this port, so a blocking dial only returns once the
+	// configured timeout elapses.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	timeout := 200 * time.Millisecond
+	start := time.Now()
+	_, err = dialGRPC(context.Background(), addr, dialConfig{timeout: timeout, block: true})
+	elapsed := time.Since(start)
+	if err == nil {
+		t.Fatal("dialGRPC() succeeded, want timeout error")
+	}
+	if elapsed < timeout || elapsed > 10*timeout {
+		t.Errorf("dialGRPC() returned after %v, want about %v", elapsed, timeout)
+	}
+}
+
+func TestDurationFromEnv(t *testing.T) {
+	t.Setenv("GRPC_DIAL_TIMEOUT", "")
+	if got := durationFromEnv("GRPC_DIAL_TIMEOUT", defaultDialTimeout); got != defaultDialTimeout {
+		t.Errorf("durationFromEnv() unset = %v, want %v", got, defaultDialTimeout)
+	}
+	t.Setenv("GRPC_DIAL_TIMEOUT", "15s")
+	if got := durationFromEnv("GRPC_DIAL_TIMEOUT", defaultDialTimeout); got != 15*time.Second {
+		t.Errorf("durationFromEnv() = %v, want 15s", got)
+	}
+}
+
+func TestGetProductsBatch(t *testing.T) {
+	small := []string{"OLJCESPC7Z", "66VCHSJNUP", "OLJCESPC7Z"}
+	var large []string
+	for i := 0; i < listProductsMinItems; i++ {
+		large = append(large, fmt.Sprintf("P%d", i))
+	}
+	tests := []struct {
+		name          string
+		ids           []string
+		listErr       error
+		wantListCalls int
+		wantGetCalls  int
+	}{
+		{"small cart", small, nil, 0, 2},
+		{"batch", large, nil, 1, 0},
+		{"fallback", large, status.Error(codes.Unimplemented, "not implemented"), 1, len(large)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeDownstreams()
+			for _, id := range large {
+				f.products[id] = &pb.Product{Id: id, PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 1}}
+			}
+			f.listErr = tt.listErr
+			cs := newTestCheckoutService(t, f)
+
+			got, err := cs.getProductsBatch(context.Background(), tt.ids)
+			if err != nil {
+				t.Fatalf("getProductsBatch() error = %v", err)
+			}
+			for _, id := range tt.ids {
+				if got[id].GetId() != id {
+					t.Errorf("getProductsBatch()[%q] = %v", id, got[id])
+				}
+			}
+			if f.listCalls != tt.wantListCalls || f.getCalls != tt.wantGetCalls {
+				t.Errorf("ListProducts/GetProduct calls = %d/%d, want %d/%d",
+					f.listCalls, f.getCalls, tt.wantListCalls, tt.wantGetCalls)
+			}
+		})
+	}
+}
+
+func TestGetProductsBatchUnknownProduct(t *testing.T) {
+	cs := newTestCheckoutService(t, newFakeDownstreams())
+	if _, err := cs.getProductsBatch(context.Background(), []string{"UNKNOWN"}); err == nil {
+		t.Error("getProductsBatch() succeeded for an unknown product, want error")
+	}
+}
+
+func TestPlaceOrderMaxCartItems(t *testing.T) {
+	tests := []struct {
+		name     string
+		items    int
+		wantCode codes.Code
+	}{
+		{"at limit", 3, codes.OK},
+		{"above limit", 4, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeDownstreams()
+			f.cart = nil
+			for i := 0; i < tt.items; i++ {
+				// Quantities don't count towards the limit, only line items.
+				f.cart = append(f.cart, &pb.CartItem{ProductId: "OLJCESPC7Z", Quantity: 50})
+			}
+			cs := newTestCheckoutService(t, f)
+			cs.maxCartItems = 3
+
+			_, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest())
+			if got := status.Code(err); got != tt.wantCode {
+				t.Errorf("PlaceOrder() code = %v, want %v (err: %v)", got, tt.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestDialTarget(t *testing.T) {
+	rr := serviceConfigWithPolicy(roundRobinPolicy)
+	pf := serviceConfigWithPolicy(pickFirstPolicy)
+	tests := []struct {
+		addr, policy       string
+		wantTarget, wantSC string
+	}{
+		{"cartservice:7070", "", "cartservice:7070", ""},
+		{"10.0.0.1:7070", "", "10.0.0.1:7070", ""},
+		{"dns:///cartservice.default.svc.cluster.local:7070", "", "dns:///cartservice.default.svc.cluster.local:7070", rr},
+		{"dns://8.8.8.8/cartservice:7070", "", "dns://8.8.8.8/cartservice:7070", rr},
+		{"cartservice:7070", roundRobinPolicy, "dns:///cartservice:7070", rr},
+		{"dns:///cartservice:7070", roundRobinPolicy, "dns:///cartservice:7070", rr},
+		{"unix:///tmp/cart.sock", roundRobinPolicy, "unix:///tmp/cart.sock", rr},
+		{"dns:///cartservice:7070", pickFirstPolicy, "dns:///cartservice:7070", pf},
+	}
+	for _, tt := range tests {
+		target, sc := dialTarget(tt.addr, tt.policy)
+		if target != tt.wantTarget || sc != tt.wantSC {
+			t.Errorf("dialTarget(%q, %q) = %q, %q, want %q, %q", tt.addr, tt.policy, target, sc, tt.wantTarget, tt.wantSC)
+		}
+	}
+	if !strings.Contains(rr, `"round_robin"`) {
+		t.Errorf("round robin service config %s doesn't name the round_robin policy", rr)
+	}
+}
+
+func TestDialGRPCDNSResolver(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	pb.RegisterCartServiceServer(srv, newFakeDownstreams())
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := dialGRPC(context.Background(), "dns:///"+lis.Addr().String(), dialConfig{timeout: 5 * time.Second, block: true})
+	if err != nil {
+		t.Fatalf("dialGRPC() error = %v", err)
+	}
+	defer conn.Close()
+	if _, err := pb.NewCartServiceClient(conn).GetCart(context.Background(), &pb.GetCartRequest{UserId: "u"}); err != nil {
+		t.Errorf("GetCart() over round_robin connection failed: %v", err)
+	}
+}
+
+func TestPlaceOrderDefaultCurrency(t *testing.T) {
+	t.Run("with default", func(t *testing.T) {
+		f := newFakeDownstreams()
+		cs := newTestCheckoutService(t, f)
+		cs.defaultCurrency = "EUR"
+		req := testPlaceOrderRequest()
+		req.UserCurrency = ""
+
+		resp, err := cs.PlaceOrder(context.Background(), req)
+		if err != nil {
+			t.Fatalf("PlaceOrder() error = %v", err)
+		}
+		if got := f.charged[0].GetCurrencyCode(); got != "EUR" {
+			t.Errorf("charged currency = %q, want EUR", got)
+		}
+		for _, it := range resp.GetOrder().GetItems() {
+			if got := it.GetCost().GetCurrencyCode(); got != "EUR" {
+				t.Errorf("item %s cost currency = %q, want EUR", it.GetItem().GetProductId(), got)
+			}
+		}
+		if got := resp.GetOrder().GetShippingCost().GetCurrencyCode(); got != "EUR" {
+			t.Errorf("shipping cost currency = %q, want EUR", got)
+		}
+	})
+	t.Run("without default", func(t *testing.T) {
+		f := newFakeDownstreams()
+		cs := newTestCheckoutService(t, f)
+		req := testPlaceOrderRequest()
+		req.UserCurrency = ""
+
+		_, err := cs.PlaceOrder(context.Background(), req)
+		if got := status.Code(err); got != codes.InvalidArgument {
+			t.Errorf("PlaceOrder() code = %v, want %v", got, codes.InvalidArgument)
+		}
+		if len(f.charged) != 0 {
+			t.Error("card was charged for an order without currency")
+		}
+	})
+}
+
+func TestPlaceOrderMoneyDebug(t *testing.T) {
+	f := newFakeDownstreams()
+	cs := newTestCheckoutService(t, f)
+	cs.flags.moneyDebug.Store(true)
+	logs := captureLogs(t)
+
+	if _, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest()); err != nil {
+		t.Fatalf("PlaceOrder() error = %v", err)
+	}
+
+	shipping := logEntries(t, logs, "money: added shipping cost")
+	if len(shipping) != 1 || shipping[0]["running_total"] != "8.990000000 USD" {
+		t.Errorf("shipping debug entries = %v, want running_total 8.990000000 USD", shipping)
+	}
+	lines := logEntries(t, logs, "money: added order line")
+	want := []struct{ subtotal, running string }{
+		{"39.980000000 USD", "48.970000000 USD"},
+		{"5.500000000 USD", "54.470000000 USD"},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d order line debug entries, want %d", len(lines), len(want))
+	}
+	for i, w := range want {
+		if lines[i]["line_subtotal"] != w.subtotal || lines[i]["running_total"] != w.running {
+			t.Errorf("line %d: subtotal=%v running_total=%v, want %s and %s",
+				i, lines[i]["line_subtotal"], lines[i]["running_total"], w.subtotal, w.running)
+		}
+	}
+}
+
+func TestPlaceOrderMoneyDebugOff(t *testing.T) {
+	cs := newTestCheckoutService(t, newFakeDownstreams())
+	logs := captureLogs(t)
+	if _, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest()); err != nil {
+		t.Fatalf("PlaceOrder() error = %v", err)
+	}
+	if got := logEntries(t, logs, "money: added order line"); len(got) != 0 {
+		t.Errorf("got %d money debug entries with MONEY_DEBUG off, want 0", len(got))
+	}
+}
+
+func TestDialGRPCRoundRobinPolicy(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	pb.RegisterCartServiceServer(srv, newFakeDownstreams())
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	// A single backend keeps working under round_robin.
+	conn, err := dialGRPC(context.Background(), lis.Addr().String(), dialConfig{timeout: 5 * time.Second, block: true, lbPolicy: roundRobinPolicy})
+	if err != nil {
+		t.Fatalf("dialGRPC() error = %v", err)
+	}
+	defer conn.Close()
+	if _, err := pb.NewCartServiceClient(conn).GetCart(context.Background(), &pb.GetCartRequest{UserId: "u"}); err != nil {
+		t.Errorf("GetCart() failed: %v", err)
+	}
+}
+
+func TestPlaceOrderEmptyCartRetry(t *testing.T) {
+	f := newFakeDownstreams()
+	f.emptyCartFailures = 1
+	cs := newTestCheckoutService(t, f)
+
+	if _, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest()); err != nil {
+		t.Fatalf("PlaceOrder() error = %v", err)
+	}
+	if f.emptyCalls != 2 || f.emptied != 1 {
+		t.Errorf("EmptyCart called %d times and succeeded %d times, want 2 and 1", f.emptyCalls, f.emptied)
+	}
+	if len(f.cart) != 0 {
+		t.Errorf("cart has %d items after the order, want 0", len(f.cart))
+	}
+}
+
+func TestPlaceOrderEmptyCartFailureKeepsOrder(t *testing.T) {
+	f := newFakeDownstreams()
+	f.emptyCartErr = status.Error(codes.Unavailable, "cart service down")
+	cs := newTestCheckoutService(t, f)
+	logs := captureLogs(t)
+
+	if _, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest()); err != nil {
+		t.Fatalf("PlaceOrder() error = %v, want the order to succeed", err)
+	}
+	if f.emptyCalls != maxAttemptsPerCall {
+		t.Errorf("EmptyCart called %d times, want %d", f.emptyCalls, maxAttemptsPerCall)
+	}
+	if len(logEntries(t, logs, "order placed but the cart could not be emptied")) != 1 {
+		t.Error("cart emptying failure was not logged")
+	}
+}
+
+func TestPlaceOrderCartNotEmptied(t *testing.T) {
+	f := newFakeDownstreams()
+	items := f.cart
+	// The cart service acknowledges EmptyCart but keeps the items.
+	f.onGetCart = func() { f.cart = items }
+	cs := newTestCheckoutService(t, f)
+	logs := captureLogs(t)
+
+	if _, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest()); err != nil {
+		t.Fatalf("PlaceOrder() error = %v, want the order to succeed", err)
+	}
+	if f.emptyCalls != emptyCartAttempts {
+		t.Errorf("EmptyCart called %d times, want %d", f.emptyCalls, emptyCartAttempts)
+	}
+	if len(logEntries(t, logs, "order placed but the cart could not be emptied")) != 1 {
+		t.Error("cart emptying failure was not logged")
+	}
+}
+
+func TestPlaceOrderEmailRetry(t *testing.T) {
+	f := newFakeDownstreams()
+	f.emailFailures = 1
+	cs := newTestCheckoutService(t, f)
+	cs.emailTimeout = time.Second
+
+	if _, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest()); err != nil {
+		t.Fatalf("PlaceOrder() error = %v", err)
+	}
+	if f.emailCalls != 2 || len(f.emails) != 1 {
+		t.Errorf("SendOrderConfirmation called %d times and sent %d emails, want 2 and 1", f.emailCalls, len(f.emails))
+	}
+}
+
+func TestPlaceOrderEmailRetriesOnce(t *testing.T) {
+	f := newFakeDownstreams()
+	f.emailFailures = 3
+	cs := newTestCheckoutService(t, f)
+
+	if _, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest()); err != nil {
+		t.Fatalf("PlaceOrder() error = %v, want the order to succeed", err)
+	}
+	if f.emailCalls != 2 {
+		t.Errorf("SendOrderConfirmation called %d times, want 2", f.emailCalls)
+	}
+}
+
+func TestPlaceOrderEmailTimeout(t *testing.T) {
+	f := newFakeDownstreams()
+	f.emailDelay = 10 * time.Second
+	cs := newTestCheckoutService(t, f)
+	cs.emailTimeout = 50 * time.Millisecond
+
+	start := time.Now()
+	if _, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest()); err != nil {
+		t.Fatalf("PlaceOrder() error = %v, want the order to succeed", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("PlaceOrder() took %v with a %v email timeout", elapsed, cs.emailTimeout)
+	}
+	if len(f.emails) != 0 {
+		t.Errorf("sent %d emails, want the timed out call to send none", len(f.emails))
+	}
+}
+
+func TestDialGRPCBufconn(t *testing.T) {
+	f := newFakeDownstreams()
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer()
+	pb.RegisterCartServiceServer(srv, f)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := dialGRPC(context.Background(), "bufnet", dialConfig{timeout: 5 * time.Second, block: true},
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}))
+	if err != nil {
+		t.Fatalf("dialGRPC() error = %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := pb.NewCartServiceClient(conn).EmptyCart(context.Background(), &pb.EmptyCartRequest{UserId: "user-1"}); err != nil {
+		t.Fatalf("EmptyCart() error = %v", err)
+	}
+	if f.emptyCalls != 1 {
+		t.Errorf("EmptyCart reached the server %d times, want 1", f.emptyCalls)
+	}
+}
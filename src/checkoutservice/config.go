@@ -0,0 +1,175 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const redactedValue = "REDACTED"
+
+// effectiveConfig records every setting read from the environment, including
+// defaults applied for unset variables, so it can be reported by
+// /debug/config.
+var effectiveConfig = struct {
+	sync.Mutex
+	values map[string]string
+}{values: make(map[string]string)}
+
+func recordConfig(envKey string, value interface{}) {
+	effectiveConfig.Lock()
+	defer effectiveConfig.Unlock()
+	effectiveConfig.values[envKey] = fmt.Sprint(value)
+}
+
+// effectiveConfigSnapshot returns a copy of the recorded settings with
+// secret values redacted.
+func effectiveConfigSnapshot() map[string]string {
+	effectiveConfig.Lock()
+	defer effectiveConfig.Unlock()
+	out := make(map[string]string, len(effectiveConfig.values))
+	for k, v := range effectiveConfig.values {
+		if isSecretConfigKey(k) && v != "" {
+			v = redactedValue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func isSecretConfigKey(envKey string) bool {
+	for _, suffix := range []string{"_SECRET", "_TOKEN", "_PASSWORD", "_API_KEY", "_CREDENTIALS"} {
+		if strings.HasSuffix(envKey, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileConfig holds the settings loaded from CONFIG_FILE, keyed by the name of
+// the environment variable they stand for.
+var fileConfig map[string]string
+
+// loadConfigFile reads a JSON object mapping environment variable names to
+// values, e.g. {"MAX_CART_ITEMS": 50, "MONEY_DEBUG": true}, from path. Its
+// settings apply to variables that aren't set in the environment.
+func loadConfigFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var raw map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	cfg := make(map[string]string, len(raw))
+	for k, v := range raw {
+		switch v := v.(type) {
+		case string:
+			cfg[k] = v
+		case json.Number:
+			cfg[k] = v.String()
+		case bool:
+			// Matches boolFromEnv, which treats only "1" as true.
+			cfg[k] = "0"
+			if v {
+				cfg[k] = "1"
+			}
+		default:
+			return fmt.Errorf("invalid config file %s: %q must be a string, number or boolean", path, k)
+		}
+	}
+	fileConfig = cfg
+	return nil
+}
+
+// getenv returns the value of envKey from the environment, falling back to
+// the config file.
+func getenv(envKey string) string {
+	if v := os.Getenv(envKey); v != "" {
+		return v
+	}
+	return fileConfig[envKey]
+}
+
+// stringFromEnv returns the value of envKey, or def when it is not set.
+func stringFromEnv(envKey string, def string) string {
+	v := getenv(envKey)
+	if v == "" {
+		v = def
+	}
+	recordConfig(envKey, v)
+	return v
+}
+
+// boolFromEnv reports whether envKey is set to "1".
+func boolFromEnv(envKey string) bool {
+	v := getenv(envKey) == "1"
+	recordConfig(envKey, v)
+	return v
+}
+
+// listFromEnv splits envKey on commas, trimming spaces and dropping empty
+// entries. It returns nil when envKey is not set.
+func listFromEnv(envKey string) []string {
+	var out []string
+	for _, v := range strings.Split(getenv(envKey), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	recordConfig(envKey, strings.Join(out, ","))
+	return out
+}
+
+// durationFromEnv parses envKey as a time.Duration, returning def when it is
+// not set.
+func durationFromEnv(envKey string, def time.Duration) time.Duration {
+	v := getenv(envKey)
+	if v == "" {
+		recordConfig(envKey, def)
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		panic(fmt.Sprintf("environment variable %q is not a valid duration: %v", envKey, err))
+	}
+	recordConfig(envKey, d)
+	return d
+}
+
+// intFromEnv parses envKey as an integer, returning def when it is not set.
+func intFromEnv(envKey string, def int) int {
+	v := getenv(envKey)
+	if v == "" {
+		recordConfig(envKey, def)
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		panic(fmt.Sprintf("environment variable %q is not a valid integer: %v", envKey, err))
+	}
+	recordConfig(envKey, n)
+	return n
+}
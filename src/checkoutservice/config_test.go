@@ -0,0 +1,113 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// withConfigFile loads contents as the config file for the duration of the
+// test.
+func withConfigFile(t *testing.T, contents string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfigFile(path); err != nil {
+		t.Fatalf("loadConfigFile() error = %v", err)
+	}
+	t.Cleanup(func() { fileConfig = nil })
+}
+
+func TestConfigFileOnly(t *testing.T) {
+	for _, k := range []string{"MAX_CART_ITEMS", "MONEY_DEBUG", "DEFAULT_CURRENCY", "EMAIL_TIMEOUT", "SUPPORTED_SHIPPING_COUNTRIES"} {
+		t.Setenv(k, "")
+	}
+	withConfigFile(t, `{
+		"MAX_CART_ITEMS": 50,
+		"MONEY_DEBUG": true,
+		"DEFAULT_CURRENCY": "EUR",
+		"EMAIL_TIMEOUT": "500ms",
+		"SUPPORTED_SHIPPING_COUNTRIES": "Canada, France"
+	}`)
+
+	if got := intFromEnv("MAX_CART_ITEMS", defaultMaxCartItems); got != 50 {
+		t.Errorf("MAX_CART_ITEMS = %d, want 50", got)
+	}
+	if !boolFromEnv("MONEY_DEBUG") {
+		t.Error("MONEY_DEBUG = false, want true")
+	}
+	if got := stringFromEnv("DEFAULT_CURRENCY", ""); got != "EUR" {
+		t.Errorf("DEFAULT_CURRENCY = %q, want EUR", got)
+	}
+	if got := durationFromEnv("EMAIL_TIMEOUT", defaultEmailTimeout); got != 500*time.Millisecond {
+		t.Errorf("EMAIL_TIMEOUT = %v, want 500ms", got)
+	}
+	if got := listFromEnv("SUPPORTED_SHIPPING_COUNTRIES"); len(got) != 2 || got[1] != "France" {
+		t.Errorf("SUPPORTED_SHIPPING_COUNTRIES = %q, want [Canada France]", got)
+	}
+}
+
+func TestConfigFileEnvOverride(t *testing.T) {
+	withConfigFile(t, `{"MAX_CART_ITEMS": 50, "MONEY_DEBUG": true, "DEFAULT_CURRENCY": "EUR"}`)
+	t.Setenv("MAX_CART_ITEMS", "10")
+	t.Setenv("MONEY_DEBUG", "0")
+	t.Setenv("DEFAULT_CURRENCY", "")
+
+	if got := intFromEnv("MAX_CART_ITEMS", defaultMaxCartItems); got != 10 {
+		t.Errorf("MAX_CART_ITEMS = %d, want the environment's 10", got)
+	}
+	if boolFromEnv("MONEY_DEBUG") {
+		t.Error("MONEY_DEBUG = true, want the environment's false")
+	}
+	// An empty variable counts as unset, so the file applies.
+	if got := stringFromEnv("DEFAULT_CURRENCY", "USD"); got != "EUR" {
+		t.Errorf("DEFAULT_CURRENCY = %q, want the file's EUR", got)
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	t.Setenv("RETRY_BUDGET", "")
+	if got := intFromEnv("RETRY_BUDGET", 7); got != 7 {
+		t.Errorf("without file or environment RETRY_BUDGET = %d, want the default 7", got)
+	}
+	withConfigFile(t, `{"RETRY_BUDGET": 3}`)
+	if got := intFromEnv("RETRY_BUDGET", 7); got != 3 {
+		t.Errorf("with a file RETRY_BUDGET = %d, want the file's 3", got)
+	}
+	t.Setenv("RETRY_BUDGET", "5")
+	if got := intFromEnv("RETRY_BUDGET", 7); got != 5 {
+		t.Errorf("with file and environment RETRY_BUDGET = %d, want the environment's 5", got)
+	}
+}
+
+func TestLoadConfigFileInvalid(t *testing.T) {
+	for _, contents := range []string{`not json`, `{"NESTED": {"a": 1}}`, `["MAX_CART_ITEMS"]`} {
+		path := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := loadConfigFile(path); err == nil {
+			t.Errorf("loadConfigFile(%q) succeeded, want error", contents)
+		}
+	}
+	if fileConfig != nil {
+		t.Errorf("fileConfig = %v after failed loads, want nil", fileConfig)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+	money "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/money"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+)
+
+type currencyPair struct{ from, to string }
+
+// rateStore holds the value of one unit of a currency in another. Both
+// ttlCache and rateMemory implement it.
+type rateStore interface {
+	get(pair currencyPair) (pb.Money, bool)
+	set(pair currencyPair, rate pb.Money)
+}
+
+// convertCurrency converts from to toCurrency. Amounts already in toCurrency
+// are returned as is without calling the currency service. If the service
+// fails and lastKnownRates is enabled, the last rate seen for the pair is
+// used instead. Orders limited by limitConversions convert with one rate per
+// currency pair.
+func (cs *checkoutService) convertCurrency(ctx context.Context, from *pb.Money, toCurrency string) (result *pb.Money, err error) {
+	if from.GetCurrencyCode() == toCurrency {
+		out := *from
+		return &out, nil
+	}
+	ctx, span := startSpan(ctx, "convertCurrency")
+	defer func() { endSpan(span, err) }()
+	if cs.currencyRates != nil {
+		result, err = cs.convertCurrencyCached(ctx, cs.currencyRates, from, toCurrency)
+	} else if rates := orderRatesFrom(ctx); rates != nil {
+		result, err = cs.convertCurrencyCached(ctx, rates, from, toCurrency)
+	} else {
+		result, err = cs.convertCurrencyRPC(ctx, from, toCurrency)
+		if err == nil && cs.lastKnownRates != nil {
+			if rate, rerr := money.Rate(*from, *result); rerr == nil {
+				cs.lastKnownRates.set(currencyPair{from.GetCurrencyCode(), toCurrency}, rate)
+			}
+		}
+	}
+	if err != nil && cs.lastKnownRates != nil {
+		if fallback, ok := cs.convertWithLastKnownRate(from, toCurrency); ok {
+			log.WithFields(logrus.Fields{
+				"from":  from.GetCurrencyCode(),
+				"to":    toCurrency,
+				"error": err.Error(),
+			}).Warn("currency: converted with last known rate")
+			return fallback, nil
+		}
+	}
+	return result, err
+}
+
+// checkOrderCurrency enforces that every amount summed into the order total
+// is in the user currency. A mismatch means a downstream service returned
+// an amount in the wrong currency, so the order fails rather than be charged
+// a wrong total.
+func checkOrderCurrency(prep orderPrep, currency string) error {
+	if c := prep.shippingCostLocalized.GetCurrencyCode(); c != currency {
+		return orderError(codes.Internal, reasonCurrencyMismatch, "shipping cost is in %q, want %q", c, currency)
+	}
+	for _, it := range prep.orderItems {
+		if c := it.GetCost().GetCurrencyCode(); c != currency {
+			return orderError(codes.Internal, reasonCurrencyMismatch, "price of %q is in %q, want %q", it.GetItem().GetProductId(), c, currency)
+		}
+	}
+	return nil
+}
+
+// roundingFromEnv reads a rounding policy, "half-up" or "half-even", from
+// key. The second result is false when the policy is empty or "none".
+func roundingFromEnv(key, defaultValue string) (money.RoundingMode, bool) {
+	v := stringFromEnv(key, defaultValue)
+	if v == "" || v == "none" {
+		return 0, false
+	}
+	mode, err := money.ParseRoundingMode(v)
+	if err != nil {
+		panic(fmt.Sprintf("environment variable %q is invalid: %v", key, err))
+	}
+	return mode, true
+}
+
+// roundConverted applies the conversion rounding policy to m.
+func (cs *checkoutService) roundConverted(m *pb.Money) *pb.Money {
+	if !cs.roundConversions {
+		return m
+	}
+	rounded := money.Round(*m, cs.conversionRounding)
+	return &rounded
+}
+
+func (cs *checkoutService) convertWithLastKnownRate(from *pb.Money, toCurrency string) (*pb.Money, bool) {
+	rate, ok := cs.lastKnownRates.get(currencyPair{from.GetCurrencyCode(), toCurrency})
+	if !ok {
+		return nil, false
+	}
+	result, err := money.MultiplyRate(*from, rate)
+	if err != nil {
+		return nil, false
+	}
+	return &result, true
+}
+
+// rateMemory holds the most recent rate seen for each currency pair. Unlike
+// ttlCache, entries never expire.
+type rateMemory struct {
+	mu    sync.Mutex
+	rates map[currencyPair]pb.Money
+}
+
+func newRateMemory() *rateMemory {
+	return &rateMemory{rates: make(map[currencyPair]pb.Money)}
+}
+
+func (m *rateMemory) get(pair currencyPair) (pb.Money, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.rates[pair]
+	return r, ok
+}
+
+func (m *rateMemory) set(pair currencyPair, rate pb.Money) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rates[pair] = rate
+}
+
+// convertCurrencyCached converts from using the rate of its currency pair in
+// rates, fetching the rate from the currency service on a miss.
+func (cs *checkoutService) convertCurrencyCached(ctx context.Context, rates rateStore, from *pb.Money, toCurrency string) (*pb.Money, error) {
+	pair := currencyPair{from: from.GetCurrencyCode(), to: toCurrency}
+	rate, ok := rates.get(pair)
+	if !ok {
+		unit, err := cs.convertCurrencyRPC(ctx, &pb.Money{CurrencyCode: pair.from, Units: 1}, toCurrency)
+		if err != nil {
+			return nil, err
+		}
+		rate = *unit
+		rates.set(pair, rate)
+		if cs.lastKnownRates != nil {
+			cs.lastKnownRates.set(pair, rate)
+		}
+	}
+	result, err := money.MultiplyRate(*from, rate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert currency: %+v", err)
+	}
+	return &result, nil
+}
+
+type ctxKeyOrderRates struct{}
+
+func orderRatesFrom(ctx context.Context) *rateMemory {
+	r, _ := ctx.Value(ctxKeyOrderRates{}).(*rateMemory)
+	return r
+}
+
+// conversionsFromEnv reads MAX_CONVERSIONS_PER_ORDER and the
+// CONVERSION_LIMIT_POLICY applied above it, "reject" or "batch".
+func conversionsFromEnv() (max int, batch bool) {
+	max = intFromEnv("MAX_CONVERSIONS_PER_ORDER", 0)
+	switch v := stringFromEnv("CONVERSION_LIMIT_POLICY", "reject"); v {
+	case "reject":
+		return max, false
+	case "batch":
+		return max, true
+	default:
+		panic(fmt.Sprintf("environment variable \"CONVERSION_LIMIT_POLICY\" is %q, want \"reject\" or \"batch\"", v))
+	}
+}
+
+// limitConversions enforces maxConversions on an order of items cart items
+// in currency, which needs one conversion per item plus one for shipping.
+// Above the limit, the order is rejected, or with batchConversions the
+// returned context makes convertCurrency fetch each currency pair's rate once
+// for the whole order. The rate cache already bounds conversions, so the
+// limit doesn't apply when it is enabled.
+func (cs *checkoutService) limitConversions(ctx context.Context, currency string, items int) (context.Context, error) {
+	needed := items + 1
+	if cs.maxConversions == 0 || cs.currencyRates != nil || currency == usdCurrency || needed <= cs.maxConversions {
+		return ctx, nil
+	}
+	if !cs.batchConversions {
+		return ctx, orderError(codes.ResourceExhausted, reasonTooManyConversions, "order needs %d currency conversions, at most %d are allowed", needed, cs.maxConversions)
+	}
+	log.Debugf("order needs %d currency conversions, converting with one rate per currency pair", needed)
+	return context.WithValue(ctx, ctxKeyOrderRates{}, newRateMemory()), nil
+}
@@ -0,0 +1,265 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+	money "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/money"
+)
+
+func TestConvertCurrencyCachesRate(t *testing.T) {
+	f := newFakeDownstreams()
+	cs := newTestCheckoutService(t, f)
+	now := time.Unix(0, 0)
+	cs.currencyRates = newTTLCache[currencyPair, pb.Money](time.Minute)
+	cs.currencyRates.now = func() time.Time { return now }
+
+	convert := func(units int64) {
+		t.Helper()
+		got, err := cs.convertCurrency(context.Background(), &pb.Money{CurrencyCode: "USD", Units: units, Nanos: 250000000}, "EUR")
+		if err != nil {
+			t.Fatalf("convertCurrency() error = %v", err)
+		}
+		if got.GetCurrencyCode() != "EUR" || got.GetUnits() != units || got.GetNanos() != 250000000 {
+			t.Errorf("convertCurrency() = %v, want EUR %d.25", got, units)
+		}
+	}
+
+	convert(10)
+	convert(42)
+	if f.conversions != 1 {
+		t.Errorf("Convert calls within TTL = %d, want 1", f.conversions)
+	}
+	now = now.Add(time.Minute)
+	convert(7)
+	if f.conversions != 2 {
+		t.Errorf("Convert calls after TTL expiry = %d, want 2", f.conversions)
+	}
+}
+
+func TestConvertCurrencySameCurrency(t *testing.T) {
+	f := newFakeDownstreams()
+	f.convertErr = status.Error(codes.Unavailable, "currency service down")
+	cs := newTestCheckoutService(t, f)
+
+	in := &pb.Money{CurrencyCode: "USD", Units: 3, Nanos: 10}
+	got, err := cs.convertCurrency(context.Background(), in, "USD")
+	if err != nil {
+		t.Fatalf("convertCurrency() error = %v", err)
+	}
+	if got.GetCurrencyCode() != "USD" || got.GetUnits() != 3 || got.GetNanos() != 10 {
+		t.Errorf("convertCurrency() = %v, want %v", got, in)
+	}
+	if f.conversions != 0 {
+		t.Errorf("Convert called %d times for a same-currency conversion, want 0", f.conversions)
+	}
+}
+
+func TestConvertCurrencyLastKnownRateFallback(t *testing.T) {
+	f := newFakeDownstreams()
+	f.rates = map[string]pb.Money{"EUR": {CurrencyCode: "EUR", Nanos: 900000000}}
+	cs := newTestCheckoutService(t, f)
+	cs.lastKnownRates = newRateMemory()
+	ctx := context.Background()
+
+	if _, err := cs.convertCurrency(ctx, &pb.Money{CurrencyCode: "USD", Units: 10}, "EUR"); err != nil {
+		t.Fatalf("convertCurrency() error = %v", err)
+	}
+	f.convertErr = status.Error(codes.Unavailable, "currency service down")
+	logs := captureLogs(t)
+
+	got, err := cs.convertCurrency(ctx, &pb.Money{CurrencyCode: "USD", Units: 20}, "EUR")
+	if err != nil {
+		t.Fatalf("convertCurrency() with fallback error = %v", err)
+	}
+	if got.GetCurrencyCode() != "EUR" || got.GetUnits() != 18 || got.GetNanos() != 0 {
+		t.Errorf("convertCurrency() = %v, want EUR 18", got)
+	}
+	entries := logEntries(t, logs, "currency: converted with last known rate")
+	if len(entries) != 1 || entries[0]["from"] != "USD" || entries[0]["to"] != "EUR" {
+		t.Errorf("fallback log entries = %v, want one USD to EUR entry", entries)
+	}
+
+	// Pairs never seen before still fail.
+	if _, err := cs.convertCurrency(ctx, &pb.Money{CurrencyCode: "USD", Units: 1}, "JPY"); err == nil {
+		t.Error("convertCurrency() to an unseen currency succeeded, want error")
+	}
+}
+
+func TestPlaceOrderConversionRounding(t *testing.T) {
+	f := newFakeDownstreams()
+	f.rates = map[string]pb.Money{"EUR": {CurrencyCode: "EUR", Nanos: 913000000}}
+	cs := newTestCheckoutService(t, f)
+	cs.roundConversions = true
+	cs.conversionRounding = money.RoundHalfEven
+
+	req := testPlaceOrderRequest()
+	req.UserCurrency = "EUR"
+	resp, err := cs.PlaceOrder(context.Background(), req)
+	if err != nil {
+		t.Fatalf("PlaceOrder() error = %v", err)
+	}
+
+	// 19.99 * 0.913 = 18.25087, 5.50 * 0.913 = 5.0215, 8.99 * 0.913 = 8.20787
+	wantCosts := []pb.Money{{CurrencyCode: "EUR", Units: 18, Nanos: 250000000}, {CurrencyCode: "EUR", Units: 5, Nanos: 20000000}}
+	for i, it := range resp.GetOrder().GetItems() {
+		if !money.AreEquals(*it.GetCost(), wantCosts[i]) {
+			t.Errorf("item %d cost = %s, want %s", i, money.Format(*it.GetCost()), money.Format(wantCosts[i]))
+		}
+	}
+	wantShipping := pb.Money{CurrencyCode: "EUR", Units: 8, Nanos: 210000000}
+	if got := *resp.GetOrder().GetShippingCost(); !money.AreEquals(got, wantShipping) {
+		t.Errorf("shipping cost = %s, want %s", money.Format(got), money.Format(wantShipping))
+	}
+
+	summary, err := summarizeOrder(resp.GetOrder())
+	if err != nil {
+		t.Fatalf("summarizeOrder() error = %v", err)
+	}
+	wantTotal := pb.Money{CurrencyCode: "EUR", Units: 49, Nanos: 730000000}
+	if !money.AreEquals(summary.total, wantTotal) || len(f.charged) != 1 || !money.AreEquals(*f.charged[0], wantTotal) {
+		t.Errorf("summary total %s, charged %v, want both %s", money.Format(summary.total), f.charged, money.Format(wantTotal))
+	}
+}
+
+func TestPlaceOrderCurrencyMismatch(t *testing.T) {
+	f := newFakeDownstreams()
+	f.convertCode = "GBP"
+	cs := newTestCheckoutService(t, f)
+
+	req := testPlaceOrderRequest()
+	req.UserCurrency = "EUR"
+	_, err := cs.PlaceOrder(context.Background(), req)
+	if status.Code(err) != codes.Internal || failureLabel(err) != "conversion" {
+		t.Errorf("PlaceOrder() error = %v, want an Internal currency mismatch", err)
+	}
+	if f.chargeCalls != 0 {
+		t.Errorf("Charge called %d times for a mismatched order, want 0", f.chargeCalls)
+	}
+}
+
+func TestPlaceOrderItemConversionRetry(t *testing.T) {
+	f := newFakeDownstreams()
+	f.convertFailures = 1
+	cs := newTestCheckoutService(t, f)
+
+	req := testPlaceOrderRequest()
+	req.UserCurrency = "EUR"
+	if _, err := cs.PlaceOrder(context.Background(), req); err != nil {
+		t.Fatalf("PlaceOrder() error = %v", err)
+	}
+	// Two items and the shipping cost, plus the retried first item.
+	if f.conversions != 4 {
+		t.Errorf("Convert called %d times, want 4", f.conversions)
+	}
+}
+
+func TestPlaceOrderItemConversionTerminalFailure(t *testing.T) {
+	f := newFakeDownstreams()
+	f.convertErr = status.Error(codes.Unavailable, "currency service is down")
+	cs := newTestCheckoutService(t, f)
+
+	req := testPlaceOrderRequest()
+	req.UserCurrency = "EUR"
+	_, err := cs.PlaceOrder(context.Background(), req)
+	if status.Code(err) != codes.Unavailable || failureLabel(err) != "conversion" {
+		t.Fatalf("PlaceOrder() error = %v, want an Unavailable conversion failure", err)
+	}
+	if msg := status.Convert(err).Message(); !strings.Contains(msg, `"OLJCESPC7Z"`) || !strings.Contains(msg, "EUR") {
+		t.Errorf("PlaceOrder() error = %q, want it to name the item and currency", msg)
+	}
+	// The first item is retried up to the currency call policy's attempts.
+	if f.conversions != maxAttemptsPerCall {
+		t.Errorf("Convert called %d times, want %d", f.conversions, maxAttemptsPerCall)
+	}
+	if f.chargeCalls != 0 {
+		t.Errorf("Charge called %d times after a failed conversion, want 0", f.chargeCalls)
+	}
+}
+
+func TestPlaceOrderConversionLimit(t *testing.T) {
+	// The test cart has two items, so an order needs three conversions.
+	tests := []struct {
+		name            string
+		max             int
+		batch           bool
+		wantErr         codes.Code
+		wantConversions int
+	}{
+		{"no limit", 0, false, codes.OK, 3},
+		{"within limit", 3, false, codes.OK, 3},
+		{"reject above limit", 2, false, codes.ResourceExhausted, 0},
+		{"batch above limit", 2, true, codes.OK, 1},
+	}
+	var charged []*pb.Money
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeDownstreams()
+			f.rates = map[string]pb.Money{"EUR": {CurrencyCode: "EUR", Nanos: 900000000}}
+			cs := newTestCheckoutService(t, f)
+			cs.maxConversions, cs.batchConversions = tt.max, tt.batch
+
+			req := testPlaceOrderRequest()
+			req.UserCurrency = "EUR"
+			_, err := cs.PlaceOrder(context.Background(), req)
+			if status.Code(err) != tt.wantErr {
+				t.Fatalf("PlaceOrder() error = %v, want code %v", err, tt.wantErr)
+			}
+			if err != nil && failureLabel(err) != "conversion" {
+				t.Errorf("failure label = %q, want conversion", failureLabel(err))
+			}
+			if f.conversions != tt.wantConversions {
+				t.Errorf("Convert called %d times, want %d", f.conversions, tt.wantConversions)
+			}
+			charged = append(charged, f.charged...)
+		})
+	}
+	for _, c := range charged[1:] {
+		if !money.AreEquals(*c, *charged[0]) {
+			t.Errorf("charged %s, want every placed order charged %s", money.Format(*c), money.Format(*charged[0]))
+		}
+	}
+}
+
+func TestCheckOrderCurrency(t *testing.T) {
+	eur := func(units int64) *pb.Money { return &pb.Money{CurrencyCode: "EUR", Units: units} }
+	item := func(cost *pb.Money) *pb.OrderItem {
+		return &pb.OrderItem{Item: &pb.CartItem{ProductId: "OLJCESPC7Z", Quantity: 1}, Cost: cost}
+	}
+	tests := []struct {
+		name    string
+		prep    orderPrep
+		wantErr bool
+	}{
+		{"consistent", orderPrep{shippingCostLocalized: eur(5), orderItems: []*pb.OrderItem{item(eur(10))}}, false},
+		{"shipping", orderPrep{shippingCostLocalized: &pb.Money{CurrencyCode: "USD", Units: 5}, orderItems: []*pb.OrderItem{item(eur(10))}}, true},
+		{"item", orderPrep{shippingCostLocalized: eur(5), orderItems: []*pb.OrderItem{item(eur(10)), item(&pb.Money{Units: 3})}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkOrderCurrency(tt.prep, "EUR"); (err != nil) != tt.wantErr {
+				t.Errorf("checkOrderCurrency() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
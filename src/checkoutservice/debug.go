@@ -0,0 +1,79 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// startDebugServer serves h on port in the background, apart from the gRPC
+// port. It returns nil when port is empty. The returned server's Addr is the
+// address it listens on.
+func startDebugServer(port string, h http.Handler) (*http.Server, error) {
+	if port == "" {
+		return nil, nil
+	}
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Addr: lis.Addr().String(), Handler: h}
+	log.Infof("starting debug HTTP server on %s", srv.Addr)
+	go func() {
+		if err := srv.Serve(lis); !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("debug HTTP server failed: %v", err)
+		}
+	}()
+	return srv, nil
+}
+
+// debugHandler serves the debug endpoints of the auxiliary HTTP server.
+// /debug/config is only registered when ENABLE_DEBUG_CONFIG=1, and
+// /admin/flags only when ADMIN_SECRET is set.
+func (cs *checkoutService) debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metricsHandler)
+	mux.HandleFunc("/health/detail", cs.healthDetailHandler)
+	if boolFromEnv("ENABLE_DEBUG_CONFIG") {
+		mux.HandleFunc("/debug/config", debugConfigHandler)
+	}
+	if secret := stringFromEnv("ADMIN_SECRET", ""); secret != "" {
+		mux.Handle("/admin/flags", cs.adminFlagsHandler(secret))
+	}
+	return mux
+}
+
+func debugConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, effectiveConfigSnapshot())
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(b)
+}
@@ -0,0 +1,89 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugConfigRedactsSecrets(t *testing.T) {
+	t.Setenv("ENABLE_DEBUG_CONFIG", "1")
+	t.Setenv("CART_SERVICE_ADDR", "cartservice:7070")
+	t.Setenv("ADMIN_SECRET", "hunter2")
+	var addr string
+	mustMapEnv(&addr, "CART_SERVICE_ADDR")
+	stringFromEnv("ADMIN_SECRET", "")
+
+	rec := httptest.NewRecorder()
+	new(checkoutService).debugHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/config", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /debug/config = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON body %q: %v", rec.Body.String(), err)
+	}
+	if got["CART_SERVICE_ADDR"] != "cartservice:7070" {
+		t.Errorf("CART_SERVICE_ADDR = %q, want %q", got["CART_SERVICE_ADDR"], "cartservice:7070")
+	}
+	if got["ADMIN_SECRET"] != redactedValue {
+		t.Errorf("ADMIN_SECRET = %q, want it redacted", got["ADMIN_SECRET"])
+	}
+}
+
+func TestDebugConfigDisabledByDefault(t *testing.T) {
+	t.Setenv("ENABLE_DEBUG_CONFIG", "")
+	rec := httptest.NewRecorder()
+	new(checkoutService).debugHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/config", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /debug/config = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestStartDebugServer(t *testing.T) {
+	srv, err := startDebugServer("0", new(checkoutService).debugHandler())
+	if err != nil || srv == nil {
+		t.Fatalf("startDebugServer() = %v, %v, want a running server", srv, err)
+	}
+	resp, err := http.Get("http://" + srv.Addr + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "checkout_failures_total") {
+		t.Errorf("GET /metrics = %d %q, want the checkout metrics", resp.StatusCode, body)
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown() error = %v", err)
+	}
+	if _, err := http.Get("http://" + srv.Addr + "/metrics"); err == nil {
+		t.Error("GET /metrics succeeded after shutdown")
+	}
+}
+
+func TestStartDebugServerDisabled(t *testing.T) {
+	srv, err := startDebugServer("", new(checkoutService).debugHandler())
+	if srv != nil || err != nil {
+		t.Errorf("startDebugServer(\"\") = %v, %v, want no server", srv, err)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errorDomain is reported as the ErrorInfo domain of PlaceOrder failures.
+const errorDomain = "checkoutservice.hipstershop"
+
+// Reasons attached to PlaceOrder failures so that clients can tell which
+// step of the checkout failed without parsing the error message.
+const (
+	reasonInvalidRequest      = "INVALID_REQUEST"
+	reasonOrderIDFailed       = "ORDER_ID_FAILED"
+	reasonCartUnavailable     = "CART_UNAVAILABLE"
+	reasonCartTooLarge        = "CART_TOO_LARGE"
+	reasonDuplicateCartItem   = "DUPLICATE_CART_ITEM"
+	reasonProductUnavailable  = "PRODUCT_UNAVAILABLE"
+	reasonProductNotFound     = "PRODUCT_NOT_FOUND"
+	reasonCurrencyUnavailable = "CURRENCY_CONVERSION_FAILED"
+	reasonCurrencyMismatch    = "CURRENCY_MISMATCH"
+	reasonTooManyConversions  = "TOO_MANY_CONVERSIONS"
+	reasonTotalMismatch       = "TOTAL_MISMATCH"
+	reasonShippingQuoteFailed = "SHIPPING_QUOTE_FAILED"
+	reasonCountryUnsupported  = "SHIPPING_COUNTRY_UNSUPPORTED"
+	reasonTokenizationFailed  = "CARD_TOKENIZATION_FAILED"
+	reasonBelowMinimumCharge  = "BELOW_MINIMUM_CHARGE"
+	reasonPaymentDeclined     = "PAYMENT_DECLINED"
+	reasonPaymentUnavailable  = "PAYMENT_UNAVAILABLE"
+	reasonShippingUnavailable = "SHIPPING_UNAVAILABLE"
+)
+
+// orderError builds a gRPC status error with the given code and message and
+// attaches an ErrorInfo detail carrying reason.
+func orderError(code codes.Code, reason string, format string, a ...interface{}) error {
+	st := status.Newf(code, format, a...)
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: reason,
+		Domain: errorDomain,
+	})
+	if err != nil {
+		log.Warnf("failed to attach error details to %q: %v", reason, err)
+		return st.Err()
+	}
+	return detailed.Err()
+}
@@ -0,0 +1,131 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// errHostNotAllowed is returned by external HTTP clients for targets outside
+// the configured allowlist.
+var errHostNotAllowed = errors.New("host is not in EXTERNAL_HOST_ALLOWLIST")
+
+// externalHosts restricts the hosts that external HTTP clients may connect
+// to. It is set from EXTERNAL_HOST_ALLOWLIST in main; nil allows every host.
+var externalHosts *hostAllowlist
+
+// hostAllowlist holds host names and networks that external HTTP calls may
+// reach. A listed name only admits public addresses, so that a name
+// resolving to an internal address can't be used to reach internal
+// services; internal addresses must be listed as an IP or CIDR.
+type hostAllowlist struct {
+	names map[string]bool
+	nets  []*net.IPNet
+}
+
+// hostAllowlistFromEnv reads EXTERNAL_HOST_ALLOWLIST, a comma-separated list
+// of host names, IPs and CIDRs. It returns nil when the variable is unset.
+func hostAllowlistFromEnv() *hostAllowlist {
+	entries := listFromEnv("EXTERNAL_HOST_ALLOWLIST")
+	if len(entries) == 0 {
+		return nil
+	}
+	a, err := newHostAllowlist(entries)
+	if err != nil {
+		panic(fmt.Sprintf("environment variable \"EXTERNAL_HOST_ALLOWLIST\" is invalid: %v", err))
+	}
+	return a
+}
+
+func newHostAllowlist(entries []string) (*hostAllowlist, error) {
+	a := &hostAllowlist{names: make(map[string]bool)}
+	for _, e := range entries {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if ip := net.ParseIP(e); ip != nil {
+			e = ip.String() + "/128"
+			if ip.To4() != nil {
+				e = ip.String() + "/32"
+			}
+		}
+		if strings.Contains(e, "/") {
+			_, n, err := net.ParseCIDR(e)
+			if err != nil {
+				return nil, err
+			}
+			a.nets = append(a.nets, n)
+			continue
+		}
+		if e == "" {
+			return nil, fmt.Errorf("empty host entry")
+		}
+		a.names[e] = true
+	}
+	return a, nil
+}
+
+// allows reports whether host, resolved to ip, may be connected to.
+func (a *hostAllowlist) allows(host string, ip net.IP) bool {
+	for _, n := range a.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return a.names[strings.ToLower(host)] && !isInternalIP(ip)
+}
+
+func isInternalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// dialContext resolves the target itself and connects to the first allowed
+// address, so the address that was checked is the one dialed.
+func (a *hostAllowlist) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			if a.allows(host, ip.IP) {
+				return dialer.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+			}
+		}
+		return nil, fmt.Errorf("%s: %w", host, errHostNotAllowed)
+	}
+}
+
+// newExternalHTTPClient returns the client used for calls to services
+// outside the cluster, restricted to externalHosts.
+func newExternalHTTPClient(timeout time.Duration) *http.Client {
+	if externalHosts == nil {
+		return &http.Client{Timeout: timeout}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would connect on our behalf, bypassing the check.
+	transport.Proxy = nil
+	transport.DialContext = externalHosts.dialContext(&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second})
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHostAllowlistAllows(t *testing.T) {
+	a, err := newHostAllowlist([]string{"analytics.example.com", "10.1.0.0/16", "192.0.2.7"})
+	if err != nil {
+		t.Fatalf("newHostAllowlist() error = %v", err)
+	}
+	tests := []struct {
+		name string
+		host string
+		ip   string
+		want bool
+	}{
+		{"listed host", "Analytics.Example.com", "203.0.113.10", true},
+		{"unlisted public host", "evil.example.net", "198.51.100.1", false},
+		{"listed host resolving to loopback", "analytics.example.com", "127.0.0.1", false},
+		{"listed host resolving to private address", "analytics.example.com", "10.2.0.1", false},
+		{"listed host resolving to link-local", "analytics.example.com", "169.254.169.254", false},
+		{"listed network", "tokens.internal", "10.1.2.3", true},
+		{"listed IP", "192.0.2.7", "192.0.2.7", true},
+		{"loopback IPv6", "localhost", "::1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := a.allows(tt.host, net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("allows(%q, %s) = %v, want %v", tt.host, tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewHostAllowlistInvalid(t *testing.T) {
+	if _, err := newHostAllowlist([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("newHostAllowlist() accepted an invalid CIDR")
+	}
+}
+
+func TestExternalHTTPClientEnforcesAllowlist(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	defer func(old *hostAllowlist) { externalHosts = old }(externalHosts)
+
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	url := "http://localhost:" + port
+	tests := []struct {
+		name    string
+		entries []string
+		wantErr bool
+	}{
+		{"listed name resolving to loopback", []string{"localhost"}, true},
+		{"other host", []string{"analytics.example.com"}, true},
+		{"loopback network", []string{"127.0.0.0/8", "::1"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := newHostAllowlist(tt.entries)
+			if err != nil {
+				t.Fatal(err)
+			}
+			externalHosts = a
+			req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+			resp, err := newExternalHTTPClient(0).Do(req)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if tt.wantErr != errors.Is(err, errHostNotAllowed) {
+				t.Errorf("GET %s error = %v, want rejected = %v", url, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// runtimeFlags holds settings that the admin endpoint can change while the
+// service runs. Every value is read atomically on each use, so a change
+// applies to the next PlaceOrder.
+type runtimeFlags struct {
+	moneyDebug atomic.Bool
+	decline    atomic.Int32
+}
+
+func (f *runtimeFlags) paymentTestDecline() paymentTestDecline {
+	return paymentTestDecline(f.decline.Load())
+}
+
+func (f *runtimeFlags) setPaymentTestDecline(d paymentTestDecline) {
+	f.decline.Store(int32(d))
+}
+
+// flagsView is the JSON form of runtimeFlags. Absent fields in an update
+// are left unchanged.
+type flagsView struct {
+	MoneyDebug         *bool   `json:"money_debug,omitempty"`
+	PaymentTestDecline *string `json:"payment_test_decline,omitempty"`
+}
+
+func (f *runtimeFlags) view() flagsView {
+	debug, decline := f.moneyDebug.Load(), f.paymentTestDecline().String()
+	return flagsView{MoneyDebug: &debug, PaymentTestDecline: &decline}
+}
+
+// adminFlagsHandler serves the runtime flags. GET returns them and POST
+// applies a partial update; both require "Authorization: Bearer <secret>".
+func (cs *checkoutService) adminFlagsHandler(secret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(secret)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var update flagsView
+			if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+				http.Error(w, "invalid flags: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			var decline paymentTestDecline
+			if update.PaymentTestDecline != nil {
+				var ok bool
+				if decline, ok = parsePaymentTestDecline(*update.PaymentTestDecline); !ok {
+					http.Error(w, "payment_test_decline must be \"off\", \"card\" or \"all\"", http.StatusBadRequest)
+					return
+				}
+				cs.flags.setPaymentTestDecline(decline)
+			}
+			if update.MoneyDebug != nil {
+				cs.flags.moneyDebug.Store(*update.MoneyDebug)
+			}
+			log.WithField("flags", update).Info("admin: updated runtime flags")
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, cs.flags.view())
+	})
+}
@@ -0,0 +1,136 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func adminRequest(method, body, secret string) *http.Request {
+	r := httptest.NewRequest(method, "/admin/flags", strings.NewReader(body))
+	if secret != "" {
+		r.Header.Set("Authorization", "Bearer "+secret)
+	}
+	return r
+}
+
+func TestAdminFlagsToggleAffectsPlaceOrder(t *testing.T) {
+	t.Setenv("ADMIN_SECRET", "s3cret")
+	f := newFakeDownstreams()
+	cs := newTestCheckoutService(t, f)
+	h := cs.debugHandler()
+
+	if _, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest()); err != nil {
+		t.Fatalf("PlaceOrder() before toggling error = %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, adminRequest(http.MethodPost, `{"payment_test_decline":"all"}`, "s3cret"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /admin/flags = %d %q, want %d", rec.Code, rec.Body.String(), http.StatusOK)
+	}
+	var got flagsView
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON body %q: %v", rec.Body.String(), err)
+	}
+	if *got.PaymentTestDecline != "all" || *got.MoneyDebug {
+		t.Errorf("flags after update = %s, want payment_test_decline=all and money_debug=false", rec.Body.String())
+	}
+
+	_, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest())
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("PlaceOrder() after toggling error = %v, want a declined payment", err)
+	}
+	if f.chargeCalls != 1 {
+		t.Errorf("Charge called %d times, want 1", f.chargeCalls)
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), adminRequest(http.MethodPost, `{"payment_test_decline":"off"}`, "s3cret"))
+	if _, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest()); err != nil {
+		t.Errorf("PlaceOrder() after toggling back error = %v", err)
+	}
+}
+
+func TestAdminFlagsRejectsUnauthorized(t *testing.T) {
+	t.Setenv("ADMIN_SECRET", "s3cret")
+	cs := new(checkoutService)
+	h := cs.debugHandler()
+	for _, secret := range []string{"", "wrong"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, adminRequest(http.MethodPost, `{"payment_test_decline":"all"}`, secret))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("POST /admin/flags with secret %q = %d, want %d", secret, rec.Code, http.StatusUnauthorized)
+		}
+	}
+	// The secret without the Bearer scheme is not accepted either.
+	bare := adminRequest(http.MethodPost, `{"payment_test_decline":"all"}`, "")
+	bare.Header.Set("Authorization", "s3cret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, bare)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("POST /admin/flags with a bare secret = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if d := cs.flags.paymentTestDecline(); d != declineNone {
+		t.Errorf("payment test decline = %v after unauthorized requests, want off", d)
+	}
+}
+
+func TestAdminFlagsBadRequest(t *testing.T) {
+	t.Setenv("ADMIN_SECRET", "s3cret")
+	h := new(checkoutService).debugHandler()
+	for _, body := range []string{`{"payment_test_decline":"sometimes"}`, `not json`} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, adminRequest(http.MethodPost, body, "s3cret"))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("POST /admin/flags %s = %d, want %d", body, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestAdminFlagsDisabledWithoutSecret(t *testing.T) {
+	t.Setenv("ADMIN_SECRET", "")
+	rec := httptest.NewRecorder()
+	new(checkoutService).debugHandler().ServeHTTP(rec, adminRequest(http.MethodGet, "", ""))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /admin/flags = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestRuntimeFlagsConcurrentToggle(t *testing.T) {
+	var flags runtimeFlags
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			flags.moneyDebug.Store(i%2 == 0)
+			flags.setPaymentTestDecline(paymentTestDecline(i % 3))
+		}(i)
+		go func() {
+			defer wg.Done()
+			flags.view()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,144 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const defaultDependencyCheckTimeout = 2 * time.Second
+
+// dependency is a downstream service checkoutservice talks to. A critical
+// dependency is one PlaceOrder can't succeed without.
+type dependency struct {
+	name     string
+	conn     *grpc.ClientConn
+	critical bool
+}
+
+func (cs *checkoutService) dependencies() []dependency {
+	return []dependency{
+		{"productcatalogservice", cs.productCatalogSvcConn, true},
+		{"cartservice", cs.cartSvcConn, true},
+		{"currencyservice", cs.currencySvcConn, true},
+		{"shippingservice", cs.shippingSvcConn, true},
+		{"paymentservice", cs.paymentSvcConn, true},
+		// Failing to send the confirmation email doesn't fail the order.
+		{"emailservice", cs.emailSvcConn, false},
+	}
+}
+
+type dependencyStatus struct {
+	Name     string `json:"name"`
+	Critical bool   `json:"critical"`
+	// Connection is the connectivity state of the client connection after
+	// the check, e.g. READY or TRANSIENT_FAILURE.
+	Connection string `json:"connection"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+func (s dependencyStatus) serving() bool {
+	return s.Status == healthpb.HealthCheckResponse_SERVING.String()
+}
+
+// checkDependencies calls the gRPC health Check of every dependency
+// concurrently, giving each at most timeout to answer, so a full check takes
+// about as long as the slowest dependency.
+func checkDependencies(ctx context.Context, deps []dependency, timeout time.Duration) []dependencyStatus {
+	out := make([]dependencyStatus, len(deps))
+	var wg sync.WaitGroup
+	for i, d := range deps {
+		wg.Add(1)
+		go func(i int, d dependency) {
+			defer wg.Done()
+			out[i] = checkDependency(ctx, d, timeout)
+		}(i, d)
+	}
+	wg.Wait()
+	return out
+}
+
+func checkDependency(ctx context.Context, d dependency, timeout time.Duration) dependencyStatus {
+	s := dependencyStatus{Name: d.name, Critical: d.critical}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	resp, err := healthpb.NewHealthClient(d.conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	s.Connection = d.conn.GetState().String()
+	if err != nil {
+		s.Status = healthpb.HealthCheckResponse_UNKNOWN.String()
+		s.Error = err.Error()
+		return s
+	}
+	s.Status = resp.GetStatus().String()
+	return s
+}
+
+// startupSelfCheck logs the health of every dependency. It returns an error
+// naming the critical dependencies that aren't serving when strict is set.
+func startupSelfCheck(ctx context.Context, deps []dependency, strict bool) error {
+	var down []string
+	for _, s := range checkDependencies(ctx, deps, defaultDependencyCheckTimeout) {
+		l := log.WithField("dependency", s.Name).WithField("critical", s.Critical).WithField("status", s.Status)
+		if s.serving() {
+			l.Info("startup check: dependency is serving")
+			continue
+		}
+		l.WithField("error", s.Error).Warn("startup check: dependency is not serving")
+		if s.Critical {
+			down = append(down, s.Name)
+		}
+	}
+	if strict && len(down) > 0 {
+		return fmt.Errorf("critical dependencies not serving: %s", strings.Join(down, ", "))
+	}
+	return nil
+}
+
+// healthDetail is the body of the /health/detail debug endpoint.
+type healthDetail struct {
+	Status       string             `json:"status"`
+	Dependencies []dependencyStatus `json:"dependencies"`
+}
+
+// healthDetailHandler checks every dependency and reports the result of each
+// as JSON. It answers 503 when a critical dependency isn't serving.
+func (cs *checkoutService) healthDetailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	detail := healthDetail{
+		Status:       healthpb.HealthCheckResponse_SERVING.String(),
+		Dependencies: checkDependencies(r.Context(), cs.dependencies(), defaultDependencyCheckTimeout),
+	}
+	code := http.StatusOK
+	for _, s := range detail.Dependencies {
+		if s.Critical && !s.serving() {
+			detail.Status = healthpb.HealthCheckResponse_NOT_SERVING.String()
+			code = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, code, detail)
+}
@@ -0,0 +1,164 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthStub returns a connection to a health server reporting st.
+func healthStub(t *testing.T, st healthpb.HealthCheckResponse_ServingStatus) *grpc.ClientConn {
+	hs := health.NewServer()
+	hs.SetServingStatus("", st)
+	return serveBufconn(t, func(s *grpc.Server) { healthpb.RegisterHealthServer(s, hs) })
+}
+
+func TestStartupSelfCheck(t *testing.T) {
+	serving := healthStub(t, healthpb.HealthCheckResponse_SERVING)
+	notServing := healthStub(t, healthpb.HealthCheckResponse_NOT_SERVING)
+	// A server without the health service answers Unimplemented.
+	noHealth := serveBufconn(t, func(*grpc.Server) {})
+
+	tests := []struct {
+		name    string
+		deps    []dependency
+		strict  bool
+		wantErr string
+	}{
+		{"all healthy", []dependency{{"cart", serving, true}, {"email", serving, false}}, true, ""},
+		{"non-critical down", []dependency{{"cart", serving, true}, {"email", notServing, false}}, true, ""},
+		{"critical down", []dependency{{"cart", notServing, true}, {"payment", noHealth, true}}, true, "cart, payment"},
+		{"critical down, lenient", []dependency{{"cart", notServing, true}}, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			err := startupSelfCheck(context.Background(), tt.deps, tt.strict)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("startupSelfCheck() error = %v, want nil", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("startupSelfCheck() error = %v, want it to name %q", err, tt.wantErr)
+			}
+			reported := len(logEntries(t, logs, "startup check: dependency is serving")) +
+				len(logEntries(t, logs, "startup check: dependency is not serving"))
+			if reported != len(tt.deps) {
+				t.Errorf("report has %d entries, want %d", reported, len(tt.deps))
+			}
+		})
+	}
+}
+
+func TestCheckDependenciesStatus(t *testing.T) {
+	got := checkDependencies(context.Background(), []dependency{
+		{"up", healthStub(t, healthpb.HealthCheckResponse_SERVING), true},
+		{"down", healthStub(t, healthpb.HealthCheckResponse_NOT_SERVING), true},
+	}, defaultDependencyCheckTimeout)
+	if !got[0].serving() || got[1].serving() {
+		t.Errorf("checkDependencies() = %+v, want only the first serving", got)
+	}
+}
+
+// hangingHealth is a health server whose Check never answers.
+type hangingHealth struct {
+	healthpb.UnimplementedHealthServer
+}
+
+func (hangingHealth) Check(ctx context.Context, _ *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestCheckDependenciesConcurrent(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	var deps []dependency
+	for _, name := range []string{"cart", "payment", "shipping", "email"} {
+		conn := serveBufconn(t, func(s *grpc.Server) { healthpb.RegisterHealthServer(s, hangingHealth{}) })
+		deps = append(deps, dependency{name, conn, true})
+	}
+
+	start := time.Now()
+	got := checkDependencies(context.Background(), deps, timeout)
+	if elapsed := time.Since(start); elapsed > 2*timeout {
+		t.Errorf("checking %d hanging dependencies took %v, want about %v", len(deps), elapsed, timeout)
+	}
+	for i, s := range got {
+		if s.Name != deps[i].name || s.serving() {
+			t.Errorf("status %d = %+v, want %s not serving", i, s, deps[i].name)
+		}
+	}
+}
+
+func TestHealthDetailHandler(t *testing.T) {
+	serving := healthStub(t, healthpb.HealthCheckResponse_SERVING)
+	notServing := healthStub(t, healthpb.HealthCheckResponse_NOT_SERVING)
+	tests := []struct {
+		name       string
+		payment    *grpc.ClientConn
+		email      *grpc.ClientConn
+		wantCode   int
+		wantStatus string
+	}{
+		{"all serving", serving, serving, http.StatusOK, "SERVING"},
+		{"non-critical down", serving, notServing, http.StatusOK, "SERVING"},
+		{"critical down", notServing, serving, http.StatusServiceUnavailable, "NOT_SERVING"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := &checkoutService{
+				productCatalogSvcConn: serving,
+				cartSvcConn:           serving,
+				currencySvcConn:       serving,
+				shippingSvcConn:       serving,
+				paymentSvcConn:        tt.payment,
+				emailSvcConn:          tt.email,
+			}
+			rec := httptest.NewRecorder()
+			cs.debugHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health/detail", nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("GET /health/detail = %d, want %d", rec.Code, tt.wantCode)
+			}
+			var got healthDetail
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("invalid JSON body %q: %v", rec.Body.String(), err)
+			}
+			if got.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", got.Status, tt.wantStatus)
+			}
+			if len(got.Dependencies) != len(cs.dependencies()) {
+				t.Fatalf("got %d dependencies, want %d", len(got.Dependencies), len(cs.dependencies()))
+			}
+			for _, d := range got.Dependencies {
+				wantStatus := "SERVING"
+				if (d.Name == "paymentservice" && tt.payment == notServing) || (d.Name == "emailservice" && tt.email == notServing) {
+					wantStatus = "NOT_SERVING"
+				}
+				if d.Status != wantStatus || d.Connection != "READY" {
+					t.Errorf("dependency %s = %s/%s, want %s/READY", d.Name, d.Status, d.Connection, wantStatus)
+				}
+			}
+		})
+	}
+}
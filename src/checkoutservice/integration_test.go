@@ -0,0 +1,82 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestPlaceOrderEndToEnd runs a full PlaceOrder against in-process stubs of
+// all six downstream services.
+func TestPlaceOrderEndToEnd(t *testing.T) {
+	f := newFakeDownstreams()
+	cs := newTestCheckoutService(t, f)
+	req := testPlaceOrderRequest()
+
+	resp, err := cs.PlaceOrder(context.Background(), req)
+	if err != nil {
+		t.Fatalf("PlaceOrder() error = %v", err)
+	}
+	order := resp.GetOrder()
+	if order.GetOrderId() == "" {
+		t.Error("PlaceOrder() returned an empty order id")
+	}
+	if order.GetShippingTrackingId() != "TRACK-1" {
+		t.Errorf("tracking id = %q, want %q", order.GetShippingTrackingId(), "TRACK-1")
+	}
+	if len(order.GetItems()) != 2 {
+		t.Errorf("order has %d items, want 2", len(order.GetItems()))
+	}
+
+	// 2 x 19.99 + 1 x 5.50 + 8.99 shipping
+	if len(f.charged) != 1 {
+		t.Fatalf("card charged %d times, want 1", len(f.charged))
+	}
+	total := f.charged[0]
+	if total.GetCurrencyCode() != "USD" || total.GetUnits() != 54 || total.GetNanos() != 470000000 {
+		t.Errorf("charged total = %v, want USD 54.47", total)
+	}
+	if f.emptied != 1 || len(f.cart) != 0 {
+		t.Errorf("cart emptied %d times with %d items left, want emptied once", f.emptied, len(f.cart))
+	}
+	if len(f.shipped) != 1 || len(f.emails) != 1 {
+		t.Errorf("shipped %d and emailed %d times, want 1 each", len(f.shipped), len(f.emails))
+	}
+	if f.emails[0].GetEmail() != req.GetEmail() || f.emails[0].GetOrder().GetOrderId() != order.GetOrderId() {
+		t.Errorf("confirmation sent for %q/%q, want %q/%q", f.emails[0].GetEmail(), f.emails[0].GetOrder().GetOrderId(), req.GetEmail(), order.GetOrderId())
+	}
+}
+
+// TestPlaceOrderEndToEndPaymentFailure checks that nothing is shipped and
+// the cart is kept when the card can't be charged.
+func TestPlaceOrderEndToEndPaymentFailure(t *testing.T) {
+	f := newFakeDownstreams()
+	f.chargeErr = status.Error(codes.InvalidArgument, "credit card expired")
+	cs := newTestCheckoutService(t, f)
+
+	if _, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest()); err == nil {
+		t.Fatal("PlaceOrder() succeeded, want payment error")
+	}
+	if len(f.shipped) != 0 || f.emptied != 0 || len(f.emails) != 0 {
+		t.Errorf("after failed payment: shipped=%d emptied=%d emailed=%d, want all 0", len(f.shipped), f.emptied, len(f.emails))
+	}
+	if len(f.cart) != 2 {
+		t.Errorf("cart has %d items after failed payment, want 2", len(f.cart))
+	}
+}
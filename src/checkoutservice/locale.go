@@ -0,0 +1,60 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// localeHeader is the metadata key carrying the customer's locale, both
+	// on incoming PlaceOrder requests and on the outgoing confirmation email
+	// request.
+	localeHeader  = "locale"
+	defaultLocale = "en"
+)
+
+// currencyLocales maps currencies used by a single country to that country's
+// locale. Shared currencies like EUR are deliberately absent.
+var currencyLocales = map[string]string{
+	"USD": "en-US",
+	"CAD": "en-CA",
+	"GBP": "en-GB",
+	"AUD": "en-AU",
+	"NZD": "en-NZ",
+	"JPY": "ja-JP",
+	"KRW": "ko-KR",
+	"CNY": "zh-CN",
+	"BRL": "pt-BR",
+	"TRY": "tr-TR",
+	"PLN": "pl-PL",
+	"SEK": "sv-SE",
+}
+
+// orderLocale returns the locale sent with the request metadata if any,
+// otherwise the locale implied by the user currency, defaulting to English.
+func orderLocale(ctx context.Context, userCurrency string) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(localeHeader); len(v) > 0 && v[0] != "" {
+			return v[0]
+		}
+	}
+	if l, ok := currencyLocales[userCurrency]; ok {
+		return l
+	}
+	return defaultLocale
+}
@@ -0,0 +1,57 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestPlaceOrderForwardsLocale(t *testing.T) {
+	tests := []struct {
+		name     string
+		currency string
+		md       metadata.MD
+		want     string
+	}{
+		{"from currency", "JPY", nil, "ja-JP"},
+		{"shared currency", "EUR", nil, defaultLocale},
+		{"explicit", "USD", metadata.Pairs(localeHeader, "fr-CA"), "fr-CA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeDownstreams()
+			cs := newTestCheckoutService(t, f)
+			req := testPlaceOrderRequest()
+			req.UserCurrency = tt.currency
+			ctx := context.Background()
+			if tt.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tt.md)
+			}
+
+			if _, err := cs.PlaceOrder(ctx, req); err != nil {
+				t.Fatalf("PlaceOrder() error = %v", err)
+			}
+			if len(f.emailMD) != 1 {
+				t.Fatalf("sent %d confirmations, want 1", len(f.emailMD))
+			}
+			if got := f.emailMD[0].Get(localeHeader); len(got) != 1 || got[0] != tt.want {
+				t.Errorf("locale metadata = %v, want [%s]", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// cardNumberPattern matches 13 to 19 digits, optionally grouped with
+	// spaces or dashes. Matches that fail the Luhn check are left alone.
+	cardNumberPattern = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+	// cvvPattern matches a CVV following its field name, as in the %+v
+	// output of a CreditCardInfo.
+	cvvPattern = regexp.MustCompile(`(?i)(cvv"?\s*[:=]\s*"?)\d{3,4}`)
+	// emailPattern captures the first character and the rest of the local
+	// part of an email address.
+	emailPattern = regexp.MustCompile(`\b([A-Za-z0-9])[A-Za-z0-9._%+-]*@([A-Za-z0-9.-]+\.[A-Za-z]{2,})`)
+)
+
+// redactSensitive masks card numbers but their last four digits, CVVs and
+// the local part of email addresses but its first character.
+func redactSensitive(s string) string {
+	s = cardNumberPattern.ReplaceAllStringFunc(s, func(m string) string {
+		digits := strings.Map(func(r rune) rune {
+			if r < '0' || r > '9' {
+				return -1
+			}
+			return r
+		}, m)
+		if !luhnValid(digits) {
+			return m
+		}
+		return strings.Repeat("*", len(digits)-4) + digits[len(digits)-4:]
+	})
+	s = cvvPattern.ReplaceAllString(s, "${1}***")
+	return emailPattern.ReplaceAllString(s, "${1}***@${2}")
+}
+
+func luhnValid(digits string) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// redactHook masks sensitive data in the message and string fields of every
+// log entry before it is written.
+type redactHook struct{}
+
+func (redactHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (redactHook) Fire(e *logrus.Entry) error {
+	e.Message = redactSensitive(e.Message)
+	for k, v := range e.Data {
+		switch v := v.(type) {
+		case string:
+			e.Data[k] = redactSensitive(v)
+		case error:
+			e.Data[k] = redactSensitive(v.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactSensitive(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"card 4432-8015-6152-0454 declined", "card ************0454 declined"},
+		{"card 4432801561520454", "card ************0454"},
+		{"order 1234567890123 shipped", "order 1234567890123 shipped"},
+		{"credit_card_cvv:672", "credit_card_cvv:***"},
+		{`"cvv": 1234`, `"cvv": ***`},
+		{`sent to "someone@example.com"`, `sent to "s***@example.com"`},
+		{"nothing to hide", "nothing to hide"},
+	}
+	for _, tt := range tests {
+		if got := redactSensitive(tt.in); got != tt.want {
+			t.Errorf("redactSensitive(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLoggerMasksCardDetails(t *testing.T) {
+	logs := captureLogs(t)
+	req := testPlaceOrderRequest()
+	log.Infof("request: %+v", req)
+	log.WithField("card", req.CreditCard.String()).WithField("email", req.Email).Info("fields")
+
+	out := strings.ToLower(logs.String())
+	for _, secret := range []string{"4432-8015-6152-0454", "4432801561520454", "cvv:672", "someone@"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("logs contain %q:\n%s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "0454") {
+		t.Errorf("logs lost the last four card digits:\n%s", out)
+	}
+}
@@ -19,6 +19,9 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"cloud.google.com/go/profiler"
@@ -27,6 +30,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
@@ -35,7 +39,6 @@ import (
 
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	"go.opentelemetry.io/otel/propagation"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
@@ -43,10 +46,47 @@ import (
 const (
 	listenPort  = "5050"
 	usdCurrency = "USD"
+
+	defaultDialTimeout  = 3 * time.Second
+	defaultMaxCartItems = 100
+	defaultRetryBudget  = 0
+
+	defaultShutdownGracePeriod = 30 * time.Second
+	defaultEmailTimeout        = 2 * time.Second
+
+	// listProductsMinItems is the number of distinct products from which
+	// getProductsBatch downloads the whole catalog with ListProducts instead
+	// of calling GetProduct per product. ListProducts is one round trip but
+	// its response grows with the catalog, so it only pays off for larger
+	// carts.
+	listProductsMinItems = 5
+
+	// emptyCartAttempts is how many times PlaceOrder empties the cart of a
+	// placed order while items are still left in it afterwards. Failing
+	// EmptyCart calls are retried by the cart call policy instead.
+	emptyCartAttempts = 2
+
+	// warningTrailer carries warnings about orders that succeeded but had a
+	// non-fatal step fail.
+	warningTrailer = "checkout-warning"
 )
 
 var log *logrus.Logger
 
+// dialCfg controls how mustConnGRPC dials downstream services. It is set from
+// the environment in main before any connection is made.
+var dialCfg = dialConfig{timeout: defaultDialTimeout}
+
+type dialConfig struct {
+	timeout time.Duration
+	// block makes dialing wait for the connection to be up, so that startup
+	// fails once timeout elapses instead of on the first RPC.
+	block bool
+	// lbPolicy is "pick_first", "round_robin", or empty for the per-address
+	// default chosen by dialTarget.
+	lbPolicy string
+}
+
 func init() {
 	log = logrus.New()
 	log.Level = logrus.DebugLevel
@@ -58,6 +98,7 @@ func init() {
 		},
 		TimestampFormat: time.RFC3339Nano,
 	}
+	log.AddHook(redactHook{})
 	log.Out = os.Stdout
 }
 
@@ -70,6 +111,9 @@ type checkoutService struct {
 
 	currencySvcAddr string
 	currencySvcConn *grpc.ClientConn
+	// currencyPool, when set, holds several currency service connections
+	// that conversions are spread over. currencySvcConn is its first one.
+	currencyPool *connPool
 
 	shippingSvcAddr string
 	shippingSvcConn *grpc.ClientConn
@@ -79,29 +123,148 @@ type checkoutService struct {
 
 	paymentSvcAddr string
 	paymentSvcConn *grpc.ClientConn
+
+	// maxCartItems caps the number of distinct line items in a cart that can
+	// be checked out. Zero means no limit.
+	maxCartItems int
+
+	// orderIDNamespace enables deterministic (v5) order IDs when not nil.
+	orderIDNamespace uuid.UUID
+
+	// fallbackCart replaces the user's cart while the cart service is
+	// unavailable. It is meant for demos and is nil unless explicitly
+	// enabled.
+	fallbackCart *fallbackCart
+
+	// duplicateItems controls how a cart listing a product more than once
+	// is handled.
+	duplicateItems duplicateItems
+
+	// orderNumbers allocates a sequential order number for every placed
+	// order. Nil disables order numbers.
+	orderNumbers *orderNumbers
+
+	// shippingCountries is the set of normalized country names orders can be
+	// shipped to. Nil allows every country.
+	shippingCountries map[string]bool
+
+	// shippingSurcharges maps shipping methods to the multiplier applied to
+	// their shipping quote. The standard method has no surcharge unless
+	// listed.
+	shippingSurcharges map[string]float64
+
+	// shippingQuotes caches USD shipping quotes by shippingQuoteKey. Nil
+	// disables caching.
+	shippingQuotes *ttlCache[string, pb.Money]
+
+	// maxConversions caps the currency conversions of one order when the
+	// rate cache is disabled. Zero means no limit. Orders above it are
+	// rejected, or with batchConversions converted with one rate per
+	// currency pair.
+	maxConversions   int
+	batchConversions bool
+
+	// currencyRates caches the value of one unit of a currency in another,
+	// so conversions within the TTL are computed locally. Nil disables
+	// caching.
+	currencyRates *ttlCache[currencyPair, pb.Money]
+
+	// roundConversions rounds every converted price and shipping cost to the
+	// minor unit of the user currency with conversionRounding. The rounded
+	// unit price is then authoritative: line costs, the order total and the
+	// charged amount are all computed from it.
+	roundConversions   bool
+	conversionRounding money.RoundingMode
+
+	// roundEmailPrices rounds the amounts of the order sent in the
+	// confirmation email to the minor unit of their currency with
+	// emailRounding. The order result and the charged amount keep full
+	// precision.
+	roundEmailPrices bool
+	emailRounding    money.RoundingMode
+
+	// lastKnownRates remembers the last rate seen for each currency pair, to
+	// convert with when the currency service is unavailable. Nil disables
+	// the fallback.
+	lastKnownRates *rateMemory
+
+	// defaultCurrency is used for orders that don't specify a user currency.
+	// When empty, such orders are rejected.
+	defaultCurrency string
+
+	// callPolicies holds the timeout and retry policy of each dependency,
+	// keyed by the names used in callDependencies. Dependencies not listed
+	// use defaultCallPolicy.
+	callPolicies map[string]callPolicy
+
+	// slowOrderThreshold is the PlaceOrder duration above which a warning
+	// with the time spent in each phase is logged. Zero disables it.
+	slowOrderThreshold time.Duration
+
+	// emailTimeout bounds the time spent sending the confirmation email,
+	// retry included. Zero means no bound beyond the request's own deadline.
+	emailTimeout time.Duration
+
+	// retryBudget is the number of retries shared by all downstream calls
+	// of one PlaceOrder. Zero leaves retries to the call policies alone.
+	retryBudget int
+
+	// analytics receives the product ids of every placed order. Nil
+	// disables reporting.
+	analytics *analyticsReporter
+
+	// auditor records every placed order in the audit log. Nil disables
+	// auditing.
+	auditor *orderAuditor
+
+	// minCharges maps currency codes to the smallest total the payment
+	// processor accepts in that currency. Currencies not listed have no
+	// minimum.
+	minCharges map[string]pb.Money
+
+	// tokenizer exchanges card details for a token before charging, so that
+	// the card number isn't forwarded to the payment service. Nil passes
+	// the card through.
+	tokenizer *cardTokenizer
+
+	// promotions maps normalized promo codes to their discount.
+	promotions map[string]promotion
+
+	// flags holds the settings that can be changed at runtime through the
+	// admin endpoint.
+	flags runtimeFlags
 }
 
 func main() {
 	ctx := context.Background()
-	if os.Getenv("ENABLE_TRACING") == "1" {
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := loadConfigFile(path); err != nil {
+			log.Fatalf("failed to load config file: %v", err)
+		}
+		log.Infof("loaded config file %s", path)
+	}
+	dialCfg.timeout = durationFromEnv("GRPC_DIAL_TIMEOUT", defaultDialTimeout)
+	dialCfg.block = boolFromEnv("GRPC_DIAL_BLOCK")
+	dialCfg.lbPolicy = lbPolicyFromEnv()
+
+	if boolFromEnv("ENABLE_TRACING") {
 		log.Info("Tracing enabled.")
-		initTracing()
+		initTracing(dialCfg.timeout)
 
 	} else {
 		log.Info("Tracing disabled.")
 	}
 
-	if os.Getenv("ENABLE_PROFILER") == "1" {
+	if boolFromEnv("ENABLE_PROFILER") {
 		log.Info("Profiling enabled.")
 		go initProfiling("checkoutservice", "1.0.0")
 	} else {
 		log.Info("Profiling disabled.")
 	}
 
-	port := listenPort
-	if os.Getenv("PORT") != "" {
-		port = os.Getenv("PORT")
-	}
+	port := stringFromEnv("PORT", listenPort)
+	shutdownGrace := durationFromEnv("SHUTDOWN_GRACE_PERIOD", defaultShutdownGracePeriod)
+	externalHosts = hostAllowlistFromEnv()
 
 	svc := new(checkoutService)
 	mustMapEnv(&svc.shippingSvcAddr, "SHIPPING_SERVICE_ADDR")
@@ -110,67 +273,149 @@ func main() {
 	mustMapEnv(&svc.currencySvcAddr, "CURRENCY_SERVICE_ADDR")
 	mustMapEnv(&svc.emailSvcAddr, "EMAIL_SERVICE_ADDR")
 	mustMapEnv(&svc.paymentSvcAddr, "PAYMENT_SERVICE_ADDR")
+	svc.maxCartItems = intFromEnv("MAX_CART_ITEMS", defaultMaxCartItems)
+	svc.duplicateItems = duplicateItemsFromEnv()
+	svc.fallbackCart = fallbackCartFromEnv()
+	svc.orderIDNamespace = orderIDNamespaceFromEnv()
+	svc.shippingCountries = shippingCountrySet(listFromEnv("SUPPORTED_SHIPPING_COUNTRIES"))
+	svc.shippingSurcharges = shippingSurchargesFromEnv()
+	svc.defaultCurrency = stringFromEnv("DEFAULT_CURRENCY", "")
+	svc.conversionRounding, svc.roundConversions = roundingFromEnv("CONVERSION_ROUNDING", "")
+	svc.emailRounding, svc.roundEmailPrices = roundingFromEnv("EMAIL_PRICE_ROUNDING", "half-up")
+	svc.maxConversions, svc.batchConversions = conversionsFromEnv()
+	if boolFromEnv("CURRENCY_FALLBACK_ENABLED") {
+		svc.lastKnownRates = newRateMemory()
+	}
+	svc.flags.moneyDebug.Store(boolFromEnv("MONEY_DEBUG"))
+	svc.retryBudget = intFromEnv("RETRY_BUDGET", defaultRetryBudget)
+	svc.emailTimeout = durationFromEnv("EMAIL_TIMEOUT", defaultEmailTimeout)
+	svc.slowOrderThreshold = durationFromEnv("SLOW_ORDER_THRESHOLD", 0)
+	svc.callPolicies = callPoliciesFromEnv()
+	if endpoint := stringFromEnv("ANALYTICS_ENDPOINT", ""); endpoint != "" {
+		svc.analytics = newAnalyticsReporter(endpoint)
+	}
+	svc.promotions = promotionsFromEnv()
+	svc.flags.setPaymentTestDecline(paymentTestDeclineFromEnv())
+	svc.minCharges = minChargesFromEnv()
+	if endpoint := stringFromEnv("TOKENIZATION_ENDPOINT", ""); endpoint != "" {
+		svc.tokenizer = newCardTokenizer(endpoint)
+	}
+	if path := stringFromEnv("ORDER_AUDIT_LOG", ""); path != "" {
+		auditor, err := newOrderAuditor(path)
+		if err != nil {
+			log.Fatalf("failed to open order audit log: %v", err)
+		}
+		defer auditor.close()
+		svc.auditor = auditor
+	}
+	orderNumbers, err := orderNumbersFromEnv()
+	if err != nil {
+		log.Fatalf("failed to open order number file: %v", err)
+	}
+	if orderNumbers != nil {
+		defer orderNumbers.close()
+		svc.orderNumbers = orderNumbers
+	}
+	if ttl := durationFromEnv("SHIPPING_QUOTE_CACHE_TTL", 0); ttl > 0 {
+		log.Infof("caching shipping quotes for %v", ttl)
+		svc.shippingQuotes = newTTLCache[string, pb.Money](ttl)
+	}
+	if ttl := durationFromEnv("CURRENCY_RATE_CACHE_TTL", 0); ttl > 0 {
+		log.Infof("caching currency rates for %v", ttl)
+		svc.currencyRates = newTTLCache[currencyPair, pb.Money](ttl)
+	}
 
 	mustConnGRPC(ctx, &svc.shippingSvcConn, svc.shippingSvcAddr)
 	mustConnGRPC(ctx, &svc.productCatalogSvcConn, svc.productCatalogSvcAddr)
 	mustConnGRPC(ctx, &svc.cartSvcConn, svc.cartSvcAddr)
-	mustConnGRPC(ctx, &svc.currencySvcConn, svc.currencySvcAddr)
+	if size := intFromEnv("CURRENCY_POOL_SIZE", 1); size > 1 {
+		pool, err := dialPool(ctx, svc.currencySvcAddr, size, dialCfg)
+		if err != nil {
+			panic(errors.Wrapf(err, "grpc: failed to connect %s", svc.currencySvcAddr))
+		}
+		svc.currencyPool = pool
+		svc.currencySvcConn = pool.conns[0]
+	} else {
+		mustConnGRPC(ctx, &svc.currencySvcConn, svc.currencySvcAddr)
+	}
 	mustConnGRPC(ctx, &svc.emailSvcConn, svc.emailSvcAddr)
 	mustConnGRPC(ctx, &svc.paymentSvcConn, svc.paymentSvcAddr)
 
 	log.Infof("service config: %+v", svc)
 
+	if boolFromEnv("STARTUP_HEALTHCHECK") {
+		if err := startupSelfCheck(ctx, svc.dependencies(), boolFromEnv("STARTUP_HEALTHCHECK_STRICT")); err != nil {
+			log.Fatalf("startup check failed: %v", err)
+		}
+	}
+
+	debugSrv, err := startDebugServer(stringFromEnv("DEBUG_HTTP_PORT", ""), svc.debugHandler())
+	if err != nil {
+		log.Fatalf("failed to start debug HTTP server: %v", err)
+	}
+
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", port))
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	var srv *grpc.Server
+	var inFlight inFlightRequests
+	access := accessLog{healthChecks: boolFromEnv("ACCESS_LOG_HEALTH_CHECKS")}
 
 	// Propagate trace context always
 	otel.SetTextMapPropagator(
 		propagation.NewCompositeTextMapPropagator(
 			propagation.TraceContext{}, propagation.Baggage{}))
 	srv = grpc.NewServer(
-		grpc.UnaryInterceptor(otelgrpc.UnaryServerInterceptor()),
-		grpc.StreamInterceptor(otelgrpc.StreamServerInterceptor()),
+		grpc.ChainUnaryInterceptor(inFlight.unaryInterceptor, access.unaryInterceptor, otelgrpc.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(inFlight.streamInterceptor, otelgrpc.StreamServerInterceptor()),
 	)
 
 	pb.RegisterCheckoutServiceServer(srv, svc)
 	healthpb.RegisterHealthServer(srv, svc)
 	log.Infof("starting to listen on tcp: %q", lis.Addr().String())
-	err = srv.Serve(lis)
-	log.Fatal(err)
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	log.Infof("received %v, draining for up to %v", <-sig, shutdownGrace)
+	debugStopped := make(chan struct{})
+	go func() {
+		defer close(debugStopped)
+		if debugSrv == nil {
+			return
+		}
+		shutdownCtx, cancel := context.WithTimeout(ctx, shutdownGrace)
+		defer cancel()
+		if err := debugSrv.Shutdown(shutdownCtx); err != nil {
+			log.Warnf("debug HTTP server did not shut down cleanly: %v", err)
+		}
+	}()
+	gracefulStop(srv, shutdownGrace, &inFlight)
+	<-debugStopped
 }
 
 func initStats() {
 	//TODO(arbrown) Implement OpenTelemetry stats
 }
 
-func initTracing() {
-	var (
-		collectorAddr string
-		collectorConn *grpc.ClientConn
-	)
-
-	ctx := context.Background()
-	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
-	defer cancel()
-
+// initTracing exports spans to the collector, dialing it with dialTimeout.
+func initTracing(dialTimeout time.Duration) {
+	var collectorAddr string
 	mustMapEnv(&collectorAddr, "COLLECTOR_SERVICE_ADDR")
-	mustConnGRPC(ctx, &collectorConn, collectorAddr)
 
-	exporter, err := otlptracegrpc.New(
-		ctx,
-		otlptracegrpc.WithGRPCConn(collectorConn))
-	if err != nil {
-		log.Warnf("warn: Failed to create trace exporter: %v", err)
-	}
+	// Spans are dropped until an exporter is registered, so a collector that
+	// is unavailable at startup never blocks request handling.
 	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(exporter),
 		sdktrace.WithSampler(sdktrace.AlwaysSample()))
 	otel.SetTracerProvider(tp)
 
+	go registerTraceExporter(context.Background(), tp, collectorAddr, dialTimeout, traceExporterBackoff)
 }
 
 func initProfiling(service, version string) {
@@ -196,23 +441,86 @@ func initProfiling(service, version string) {
 }
 
 func mustMapEnv(target *string, envKey string) {
-	v := os.Getenv(envKey)
+	v := getenv(envKey)
 	if v == "" {
 		panic(fmt.Sprintf("environment variable %q not set", envKey))
 	}
+	recordConfig(envKey, v)
 	*target = v
 }
 
 func mustConnGRPC(ctx context.Context, conn **grpc.ClientConn, addr string) {
 	var err error
-	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
+	*conn, err = dialGRPC(ctx, addr, dialCfg)
+	if err != nil {
+		panic(errors.Wrapf(err, "grpc: failed to connect %s", addr))
+	}
+}
+
+// dialGRPC is the single place client connections are made, so that every
+// downstream connection gets the same credentials, interceptors and load
+// balancing. extra options are applied last and can override the defaults,
+// e.g. to dial an in-process listener in tests.
+func dialGRPC(ctx context.Context, addr string, cfg dialConfig, extra ...grpc.DialOption) (*grpc.ClientConn, error) {
+	target, sc := dialTarget(addr, cfg.lbPolicy)
+	log.Infof("dialing %s (blocking=%t, timeout=%v)", target, cfg.block, cfg.timeout)
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout)
 	defer cancel()
-	*conn, err = grpc.DialContext(ctx, addr,
+	opts := clientDialOptions(cfg)
+	if sc != "" {
+		opts = append(opts, grpc.WithDefaultServiceConfig(sc))
+	}
+	return grpc.DialContext(ctx, target, append(opts, extra...)...)
+}
+
+// clientDialOptions returns the dial options shared by all downstream
+// connections.
+func clientDialOptions(cfg dialConfig) []grpc.DialOption {
+	opts := []grpc.DialOption{
 		grpc.WithInsecure(),
 		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
-		grpc.WithStreamInterceptor(otelgrpc.StreamClientInterceptor()))
-	if err != nil {
-		panic(errors.Wrapf(err, "grpc: failed to connect %s", addr))
+		grpc.WithStreamInterceptor(otelgrpc.StreamClientInterceptor())}
+	if cfg.block {
+		opts = append(opts, grpc.WithBlock())
+	}
+	return opts
+}
+
+const (
+	pickFirstPolicy  = "pick_first"
+	roundRobinPolicy = "round_robin"
+)
+
+func lbPolicyFromEnv() string {
+	switch p := stringFromEnv("GRPC_LB_POLICY", ""); p {
+	case "", pickFirstPolicy, roundRobinPolicy:
+		return p
+	default:
+		panic(fmt.Sprintf("environment variable \"GRPC_LB_POLICY\" must be %q or %q, got %q", pickFirstPolicy, roundRobinPolicy, p))
+	}
+}
+
+func serviceConfigWithPolicy(policy string) string {
+	return fmt.Sprintf(`{"loadBalancingConfig": [{%q: {}}]}`, policy)
+}
+
+// dialTarget returns the target and default service config to dial addr
+// with. Without an explicit policy, addresses using the DNS resolver (e.g.
+// "dns:///cartservice:7070", typically a headless service) are load balanced
+// across all resolved records and plain host:port addresses keep gRPC's
+// defaults. With round_robin, plain addresses are resolved through DNS too
+// so that every backend is used; a single record behaves as before.
+func dialTarget(addr, policy string) (target, serviceConfig string) {
+	explicitDNS := strings.HasPrefix(addr, "dns:")
+	switch {
+	case policy == roundRobinPolicy && !explicitDNS && !strings.Contains(addr, "://"):
+		return "dns:///" + addr, serviceConfigWithPolicy(roundRobinPolicy)
+	case policy != "":
+		return addr, serviceConfigWithPolicy(policy)
+	case explicitDNS:
+		return addr, serviceConfigWithPolicy(roundRobinPolicy)
+	default:
+		return addr, ""
 	}
 }
 
@@ -224,40 +532,116 @@ func (cs *checkoutService) Watch(req *healthpb.HealthCheckRequest, ws healthpb.H
 	return status.Errorf(codes.Unimplemented, "health check via Watch not implemented")
 }
 
-func (cs *checkoutService) PlaceOrder(ctx context.Context, req *pb.PlaceOrderRequest) (*pb.PlaceOrderResponse, error) {
+func (cs *checkoutService) PlaceOrder(ctx context.Context, req *pb.PlaceOrderRequest) (resp *pb.PlaceOrderResponse, err error) {
+	if cs.slowOrderThreshold > 0 {
+		steps := new(stepTimings)
+		ctx = withStepTimings(ctx, steps)
+		defer func(start time.Time) { cs.warnIfSlow(start, steps, req, resp) }(time.Now())
+	}
+	resp, err = cs.placeOrder(ctx, req)
+	if err != nil {
+		checkoutFailures.inc(failureLabel(err))
+	}
+	return resp, err
+}
+
+func (cs *checkoutService) placeOrder(ctx context.Context, req *pb.PlaceOrderRequest) (*pb.PlaceOrderResponse, error) {
 	log.Infof("[PlaceOrder] user_id=%q user_currency=%q", req.UserId, req.UserCurrency)
+	if req.Address == nil {
+		return nil, orderError(codes.InvalidArgument, reasonInvalidRequest, "shipping address is required")
+	}
+	if req.CreditCard == nil {
+		return nil, orderError(codes.InvalidArgument, reasonInvalidRequest, "credit card is required")
+	}
+
+	if cs.retryBudget > 0 {
+		ctx = withRetryBudget(ctx, cs.retryBudget)
+	}
+
+	userCurrency := req.UserCurrency
+	if userCurrency == "" {
+		if cs.defaultCurrency == "" {
+			return nil, orderError(codes.InvalidArgument, reasonInvalidRequest, "user currency is required")
+		}
+		log.Infof("no user currency given, using default currency %q", cs.defaultCurrency)
+		userCurrency = cs.defaultCurrency
+	}
+	if !cs.shipsTo(req.Address) {
+		return nil, orderError(codes.FailedPrecondition, reasonCountryUnsupported, "shipping to %q is not supported", req.GetAddress().GetCountry())
+	}
+	method := shippingMethod(ctx)
+	surcharge, ok := cs.shippingSurcharge(method)
+	if !ok {
+		return nil, orderError(codes.InvalidArgument, reasonInvalidRequest, "unknown shipping method %q", method)
+	}
 
-	orderID, err := uuid.NewUUID()
+	orderID, err := cs.newOrderID(ctx, req.UserId)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to generate order uuid")
+		return nil, orderError(codes.Internal, reasonOrderIDFailed, "failed to generate order uuid")
 	}
 
-	prep, err := cs.prepareOrderItemsAndShippingQuoteFromCart(ctx, req.UserId, req.UserCurrency, req.Address)
+	prep, err := cs.prepareOrderItemsAndShippingQuoteFromCart(ctx, req.UserId, userCurrency, req.Address, surcharge)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, err.Error())
+		return nil, err
+	}
+	if err := checkOrderCurrency(prep, userCurrency); err != nil {
+		return nil, err
 	}
 
-	total := pb.Money{CurrencyCode: req.UserCurrency,
-		Units: 0,
-		Nanos: 0}
-	total = money.Must(money.Sum(total, *prep.shippingCostLocalized))
-	for _, it := range prep.orderItems {
-		multPrice := money.MultiplySlow(*it.Cost, uint32(it.GetItem().GetQuantity()))
-		total = money.Must(money.Sum(total, multPrice))
+	total := cs.orderTotal(orderID.String(), userCurrency, prep)
+	summary, err := summarizeOrder(&pb.OrderResult{Items: prep.orderItems, ShippingCost: prep.shippingCostLocalized})
+	if err != nil || !money.AreEquals(summary.total, total) {
+		log.Errorf("order total cross-check failed: total=%s summary=%s err=%v", money.Format(total), money.Format(summary.total), err)
+		return nil, orderError(codes.Internal, reasonTotalMismatch, "failed to compute order total")
+	}
+	if code := promoCode(ctx); code != "" {
+		total = cs.applyPromotion(ctx, code, total)
 	}
 
-	txID, err := cs.chargeCard(ctx, &total, req.CreditCard)
+	if min, ok := cs.belowMinimumCharge(total); ok {
+		return nil, orderError(codes.FailedPrecondition, reasonBelowMinimumCharge, "order total %s is below the minimum charge of %s",
+			money.FormatLocalized(total), money.FormatLocalized(min))
+	}
+	if cs.flags.paymentTestDecline().declines(req.CreditCard) {
+		log.Warn("payment test mode: declining the charge")
+		return nil, orderError(codes.FailedPrecondition, reasonPaymentDeclined, "card declined by payment test mode")
+	}
+	card := req.CreditCard
+	if cs.tokenizer != nil {
+		if card, err = cs.tokenizer.tokenize(ctx, card); err != nil {
+			return nil, orderError(codes.Unavailable, reasonTokenizationFailed, "failed to tokenize card: %v", err)
+		}
+	}
+	txID, err := cs.chargeCard(ctx, &total, card)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to charge card: %+v", err)
+		if isRetryable(err) || status.Code(err) == codes.DeadlineExceeded {
+			return nil, orderError(codes.Unavailable, reasonPaymentUnavailable, "failed to charge card: %+v", err)
+		}
+		return nil, orderError(codes.FailedPrecondition, reasonPaymentDeclined, "failed to charge card: %+v", err)
 	}
 	log.Infof("payment went through (transaction_id: %s)", txID)
 
 	shippingTrackingID, err := cs.shipOrder(ctx, req.Address, prep.cartItems)
 	if err != nil {
-		return nil, status.Errorf(codes.Unavailable, "shipping error: %+v", err)
+		return nil, orderError(codes.Unavailable, reasonShippingUnavailable, "shipping error: %+v", err)
+	}
+	if cs.orderNumbers != nil {
+		if number, err := cs.orderNumbers.next(); err != nil {
+			log.WithFields(logrus.Fields{"order_id": orderID.String(), "error": err.Error()}).Warn("order placed without an order number")
+		} else {
+			log.WithFields(logrus.Fields{"order_id": orderID.String(), "order_number": number}).Info("assigned order number")
+			_ = grpc.SetHeader(ctx, metadata.Pairs(orderNumberHeader, number))
+		}
 	}
 
-	_ = cs.emptyUserCart(ctx, req.UserId)
+	if err := cs.clearUserCart(ctx, req.UserId); err != nil {
+		log.WithFields(logrus.Fields{
+			"order_id": orderID.String(),
+			"user_id":  req.UserId,
+			"error":    err.Error(),
+		}).Warn("order placed but the cart could not be emptied")
+		_ = grpc.SetTrailer(ctx, metadata.Pairs(warningTrailer, "cart could not be emptied"))
+	}
 
 	orderResult := &pb.OrderResult{
 		OrderId:            orderID.String(),
@@ -267,123 +651,336 @@ func (cs *checkoutService) PlaceOrder(ctx context.Context, req *pb.PlaceOrderReq
 		Items:              prep.orderItems,
 	}
 
-	if err := cs.sendOrderConfirmation(ctx, req.Email, orderResult); err != nil {
+	locale := orderLocale(ctx, userCurrency)
+	emailOrder := orderResult
+	if cs.roundEmailPrices {
+		emailOrder = roundOrderForDisplay(orderResult, cs.emailRounding)
+	}
+	if err := cs.sendOrderConfirmation(ctx, req.Email, locale, emailOrder); err != nil {
 		log.Warnf("failed to send order confirmation to %q: %+v", req.Email, err)
 	} else {
 		log.Infof("order confirmation email sent to %q", req.Email)
 	}
+	if cs.auditor != nil {
+		if err := cs.auditor.record(req.UserId, orderResult, total); err != nil {
+			log.Errorf("failed to write audit record for order %s: %v", orderResult.GetOrderId(), err)
+		}
+	}
+	if cs.analytics != nil {
+		cs.analytics.reportOrder(orderResult)
+	}
 	resp := &pb.PlaceOrderResponse{Order: orderResult}
 	return resp, nil
 }
 
+// orderTotal sums the shipping cost and every line of the order. When
+// the moneyDebug flag is set, each intermediate value is logged.
+func (cs *checkoutService) orderTotal(orderID, currency string, prep orderPrep) pb.Money {
+	total := pb.Money{CurrencyCode: currency,
+		Units: 0,
+		Nanos: 0}
+	total = money.Must(money.Sum(total, *prep.shippingCostLocalized))
+	debug := cs.flags.moneyDebug.Load()
+	if debug {
+		log.WithFields(logrus.Fields{
+			"order_id":      orderID,
+			"shipping_cost": money.Format(*prep.shippingCostLocalized),
+			"running_total": money.Format(total),
+		}).Debug("money: added shipping cost")
+	}
+	for _, it := range prep.orderItems {
+		multPrice := money.MultiplySlow(*it.Cost, uint32(it.GetItem().GetQuantity()))
+		total = money.Must(money.Sum(total, multPrice))
+		if debug {
+			log.WithFields(logrus.Fields{
+				"order_id":      orderID,
+				"product_id":    it.GetItem().GetProductId(),
+				"line_cost":     money.Format(*it.Cost),
+				"quantity":      it.GetItem().GetQuantity(),
+				"line_subtotal": money.Format(multPrice),
+				"running_total": money.Format(total),
+			}).Debug("money: added order line")
+		}
+	}
+	return total
+}
+
 type orderPrep struct {
 	orderItems            []*pb.OrderItem
 	cartItems             []*pb.CartItem
 	shippingCostLocalized *pb.Money
 }
 
-func (cs *checkoutService) prepareOrderItemsAndShippingQuoteFromCart(ctx context.Context, userID, userCurrency string, address *pb.Address) (orderPrep, error) {
+// prepareOrderItemsAndShippingQuoteFromCart returns a gRPC status error
+// carrying the reason of the failed step. The shipping quote is multiplied by
+// the surcharge rate of the shipping method.
+func (cs *checkoutService) prepareOrderItemsAndShippingQuoteFromCart(ctx context.Context, userID, userCurrency string, address *pb.Address, surcharge pb.Money) (orderPrep, error) {
 	var out orderPrep
-	cartItems, err := cs.getUserCart(ctx, userID)
+	cartItems, err := cs.cartForOrder(ctx, userID)
 	if err != nil {
-		return out, fmt.Errorf("cart failure: %+v", err)
+		return out, orderError(codes.Unavailable, reasonCartUnavailable, "cart failure: %+v", err)
+	}
+	if cartItems, err = cs.resolveDuplicateItems(cartItems); err != nil {
+		return out, err
+	}
+	if cs.maxCartItems > 0 && len(cartItems) > cs.maxCartItems {
+		return out, orderError(codes.InvalidArgument, reasonCartTooLarge, "cart has %d items, at most %d are allowed", len(cartItems), cs.maxCartItems)
+	}
+	if ctx, err = cs.limitConversions(ctx, userCurrency, len(cartItems)); err != nil {
+		return out, err
 	}
 	orderItems, err := cs.prepOrderItems(ctx, cartItems, userCurrency)
 	if err != nil {
-		return out, fmt.Errorf("failed to prepare order: %+v", err)
+		return out, err
 	}
 	shippingUSD, err := cs.quoteShipping(ctx, address, cartItems)
 	if err != nil {
-		return out, fmt.Errorf("shipping quote failure: %+v", err)
+		return out, orderError(codes.Unavailable, reasonShippingQuoteFailed, "shipping quote failure: %+v", err)
+	}
+	if shippingUSD == nil {
+		return out, orderError(codes.Unavailable, reasonShippingQuoteFailed, "shipping quote has no cost")
+	}
+	surcharge.CurrencyCode = shippingUSD.GetCurrencyCode()
+	surcharged, err := money.MultiplyRate(*shippingUSD, surcharge)
+	if err != nil {
+		return out, orderError(codes.Internal, reasonShippingQuoteFailed, "failed to apply shipping surcharge: %+v", err)
 	}
+	shippingUSD = &surcharged
 	shippingPrice, err := cs.convertCurrency(ctx, shippingUSD, userCurrency)
 	if err != nil {
-		return out, fmt.Errorf("failed to convert shipping cost to currency: %+v", err)
+		return out, orderError(codes.Unavailable, reasonCurrencyUnavailable, "failed to convert shipping cost to currency: %+v", err)
 	}
 
-	out.shippingCostLocalized = shippingPrice
+	out.shippingCostLocalized = cs.roundConverted(shippingPrice)
 	out.cartItems = cartItems
 	out.orderItems = orderItems
 	return out, nil
 }
 
-func (cs *checkoutService) quoteShipping(ctx context.Context, address *pb.Address, items []*pb.CartItem) (*pb.Money, error) {
-	shippingQuote, err := pb.NewShippingServiceClient(cs.shippingSvcConn).
-		GetQuote(ctx, &pb.GetQuoteRequest{
-			Address: address,
-			Items:   items})
+func (cs *checkoutService) quoteShipping(ctx context.Context, address *pb.Address, items []*pb.CartItem) (_ *pb.Money, err error) {
+	ctx, span := startSpan(ctx, "quoteShipping")
+	defer func() { endSpan(span, err) }()
+	var key string
+	if cs.shippingQuotes != nil {
+		key = shippingQuoteKey(address, items)
+		if cost, ok := cs.shippingQuotes.get(key); ok {
+			return &cost, nil
+		}
+	}
+	var shippingQuote *pb.GetQuoteResponse
+	err = cs.callWithPolicy(ctx, "quoteShipping", func(ctx context.Context) (err error) {
+		shippingQuote, err = pb.NewShippingServiceClient(cs.shippingSvcConn).
+			GetQuote(ctx, &pb.GetQuoteRequest{
+				Address: address,
+				Items:   items})
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get shipping quote: %+v", err)
 	}
+	if cs.shippingQuotes != nil && shippingQuote.GetCostUsd() != nil {
+		cs.shippingQuotes.set(key, *shippingQuote.GetCostUsd())
+	}
 	return shippingQuote.GetCostUsd(), nil
 }
 
 func (cs *checkoutService) getUserCart(ctx context.Context, userID string) ([]*pb.CartItem, error) {
-	cart, err := pb.NewCartServiceClient(cs.cartSvcConn).GetCart(ctx, &pb.GetCartRequest{UserId: userID})
+	var cart *pb.Cart
+	err := cs.callWithPolicy(ctx, "getUserCart", func(ctx context.Context) (err error) {
+		cart, err = pb.NewCartServiceClient(cs.cartSvcConn).GetCart(ctx, &pb.GetCartRequest{UserId: userID})
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user cart during checkout: %+v", err)
+		return nil, fmt.Errorf("failed to get user cart during checkout: %w", err)
 	}
 	return cart.GetItems(), nil
 }
 
 func (cs *checkoutService) emptyUserCart(ctx context.Context, userID string) error {
-	if _, err := pb.NewCartServiceClient(cs.cartSvcConn).EmptyCart(ctx, &pb.EmptyCartRequest{UserId: userID}); err != nil {
+	err := cs.callWithPolicy(ctx, "emptyCart", func(ctx context.Context) error {
+		_, err := pb.NewCartServiceClient(cs.cartSvcConn).EmptyCart(ctx, &pb.EmptyCartRequest{UserId: userID})
+		return err
+	})
+	if err != nil {
 		return fmt.Errorf("failed to empty user cart during checkout: %+v", err)
 	}
 	return nil
 }
 
-func (cs *checkoutService) prepOrderItems(ctx context.Context, items []*pb.CartItem, userCurrency string) ([]*pb.OrderItem, error) {
+// clearUserCart empties the cart of a placed order and checks that it is
+// empty, emptying it again up to emptyCartAttempts times in total while
+// items are left. The order has already gone through by then, so the caller
+// only reports a failure.
+func (cs *checkoutService) clearUserCart(ctx context.Context, userID string) error {
+	var err error
+	for attempt := 1; attempt <= emptyCartAttempts; attempt++ {
+		if err = cs.emptyUserCart(ctx, userID); err != nil {
+			return err
+		}
+		var left int
+		if left, err = cs.cartItemsLeft(ctx, userID); err != nil {
+			return err
+		}
+		if left == 0 {
+			return nil
+		}
+		err = fmt.Errorf("cart still has %d items after emptying", left)
+		log.Warnf("attempt %d to empty the cart of user %q failed: %v", attempt, userID, err)
+	}
+	return err
+}
+
+func (cs *checkoutService) cartItemsLeft(ctx context.Context, userID string) (int, error) {
+	items, err := cs.getUserCart(ctx, userID)
+	return len(items), err
+}
+
+func (cs *checkoutService) prepOrderItems(ctx context.Context, items []*pb.CartItem, userCurrency string) (_ []*pb.OrderItem, err error) {
+	ctx, span := startSpan(ctx, "prepOrderItems")
+	defer func() { endSpan(span, err) }()
 	out := make([]*pb.OrderItem, len(items))
-	cl := pb.NewProductCatalogServiceClient(cs.productCatalogSvcConn)
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.GetProductId()
+	}
+	products, err := cs.getProductsBatch(ctx, ids)
+	if status.Code(err) == codes.NotFound {
+		return nil, orderError(codes.NotFound, reasonProductNotFound, "failed to prepare order: %+v", err)
+	}
+	if err != nil {
+		return nil, orderError(codes.Unavailable, reasonProductUnavailable, "failed to prepare order: %+v", err)
+	}
 
 	for i, item := range items {
-		product, err := cl.GetProduct(ctx, &pb.GetProductRequest{Id: item.GetProductId()})
-		if err != nil {
-			return nil, fmt.Errorf("failed to get product #%q", item.GetProductId())
+		product := products[item.GetProductId()]
+		if product.GetPriceUsd() == nil {
+			return nil, orderError(codes.Unavailable, reasonProductUnavailable, "failed to prepare order: product %q has no price", item.GetProductId())
 		}
 		price, err := cs.convertCurrency(ctx, product.GetPriceUsd(), userCurrency)
 		if err != nil {
-			return nil, fmt.Errorf("failed to convert price of %q to %s", item.GetProductId(), userCurrency)
+			return nil, orderError(codes.Unavailable, reasonCurrencyUnavailable, "failed to prepare order: failed to convert price of %q to %s: %v",
+				item.GetProductId(), userCurrency, err)
 		}
 		out[i] = &pb.OrderItem{
 			Item: item,
-			Cost: price}
+			Cost: cs.roundConverted(price)}
 	}
 	return out, nil
 }
 
-func (cs *checkoutService) convertCurrency(ctx context.Context, from *pb.Money, toCurrency string) (*pb.Money, error) {
-	result, err := pb.NewCurrencyServiceClient(cs.currencySvcConn).Convert(context.TODO(), &pb.CurrencyConversionRequest{
-		From:   from,
-		ToCode: toCurrency})
+// getProductsBatch looks up all ids. Carts with at least
+// listProductsMinItems distinct products are served from a single
+// ListProducts call; smaller carts, products ListProducts did not return and
+// catalogs without ListProducts use one GetProduct call per id.
+func (cs *checkoutService) getProductsBatch(ctx context.Context, ids []string) (map[string]*pb.Product, error) {
+	cl := pb.NewProductCatalogServiceClient(cs.productCatalogSvcConn)
+	out := make(map[string]*pb.Product, len(ids))
+
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	if len(wanted) >= listProductsMinItems {
+		var listed *pb.ListProductsResponse
+		err := cs.callWithPolicy(ctx, "listProducts", func(ctx context.Context) (err error) {
+			listed, err = cl.ListProducts(ctx, &pb.Empty{})
+			return err
+		})
+		if err != nil && status.Code(err) != codes.Unimplemented {
+			return nil, fmt.Errorf("failed to list products: %w", err)
+		}
+		for _, p := range listed.GetProducts() {
+			if wanted[p.GetId()] {
+				out[p.GetId()] = p
+			}
+		}
+	}
+
+	for _, id := range ids {
+		if _, ok := out[id]; ok {
+			continue
+		}
+		var product *pb.Product
+		err := cs.callWithPolicy(ctx, "getProduct", func(ctx context.Context) (err error) {
+			product, err = cl.GetProduct(ctx, &pb.GetProductRequest{Id: id})
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get product #%q: %w", id, err)
+		}
+		out[id] = product
+	}
+	return out, nil
+}
+
+func (cs *checkoutService) convertCurrencyRPC(ctx context.Context, from *pb.Money, toCurrency string) (*pb.Money, error) {
+	conn := cs.currencySvcConn
+	if cs.currencyPool != nil {
+		conn = cs.currencyPool.pick()
+	}
+	var result *pb.Money
+	err := cs.callWithPolicy(ctx, "convertCurrency", func(ctx context.Context) (err error) {
+		result, err = pb.NewCurrencyServiceClient(conn).Convert(ctx, &pb.CurrencyConversionRequest{
+			From:   from,
+			ToCode: toCurrency})
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert currency: %+v", err)
 	}
 	return result, err
 }
 
-func (cs *checkoutService) chargeCard(ctx context.Context, amount *pb.Money, paymentInfo *pb.CreditCardInfo) (string, error) {
-	paymentResp, err := pb.NewPaymentServiceClient(cs.paymentSvcConn).Charge(ctx, &pb.ChargeRequest{
-		Amount:     amount,
-		CreditCard: paymentInfo})
+func (cs *checkoutService) chargeCard(ctx context.Context, amount *pb.Money, paymentInfo *pb.CreditCardInfo) (_ string, err error) {
+	ctx, span := startSpan(ctx, "chargeCard")
+	defer func() { endSpan(span, err) }()
+	var paymentResp *pb.ChargeResponse
+	err = cs.callWithPolicy(ctx, "chargeCard", func(ctx context.Context) (err error) {
+		paymentResp, err = pb.NewPaymentServiceClient(cs.paymentSvcConn).Charge(ctx, &pb.ChargeRequest{
+			Amount:     amount,
+			CreditCard: paymentInfo})
+		return err
+	})
 	if err != nil {
-		return "", fmt.Errorf("could not charge the card: %+v", err)
+		return "", fmt.Errorf("could not charge the card: %w", err)
 	}
 	return paymentResp.GetTransactionId(), nil
 }
 
-func (cs *checkoutService) sendOrderConfirmation(ctx context.Context, email string, order *pb.OrderResult) error {
-	_, err := pb.NewEmailServiceClient(cs.emailSvcConn).SendOrderConfirmation(ctx, &pb.SendOrderConfirmationRequest{
+// sendOrderConfirmation sends the order confirmation email under the email
+// call policy, which retries a transient failure once unless configured
+// otherwise. All attempts together take at most emailTimeout. locale is
+// passed to the email service as request metadata so it can pick a
+// localized template.
+func (cs *checkoutService) sendOrderConfirmation(ctx context.Context, email, locale string, order *pb.OrderResult) (err error) {
+	ctx, span := startSpan(ctx, "sendOrderConfirmation")
+	defer func() { endSpan(span, err) }()
+	if cs.emailTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cs.emailTimeout)
+		defer cancel()
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, localeHeader, locale)
+	client := pb.NewEmailServiceClient(cs.emailSvcConn)
+	req := &pb.SendOrderConfirmationRequest{
 		Email: email,
-		Order: order})
-	return err
+		Order: order}
+	return cs.callWithPolicy(ctx, "sendOrderConfirmation", func(ctx context.Context) error {
+		_, err := client.SendOrderConfirmation(ctx, req)
+		return err
+	})
 }
 
-func (cs *checkoutService) shipOrder(ctx context.Context, address *pb.Address, items []*pb.CartItem) (string, error) {
-	resp, err := pb.NewShippingServiceClient(cs.shippingSvcConn).ShipOrder(ctx, &pb.ShipOrderRequest{
-		Address: address,
-		Items:   items})
+func (cs *checkoutService) shipOrder(ctx context.Context, address *pb.Address, items []*pb.CartItem) (_ string, err error) {
+	ctx, span := startSpan(ctx, "shipOrder")
+	defer func() { endSpan(span, err) }()
+	var resp *pb.ShipOrderResponse
+	err = cs.callWithPolicy(ctx, "shipOrder", func(ctx context.Context) (err error) {
+		resp, err = pb.NewShippingServiceClient(cs.shippingSvcConn).ShipOrder(ctx, &pb.ShipOrderRequest{
+			Address: address,
+			Items:   items})
+		return err
+	})
 	if err != nil {
 		return "", fmt.Errorf("shipment failed: %+v", err)
 	}
@@ -0,0 +1,115 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+)
+
+// counterVec is a monotonic counter partitioned by the value of one label,
+// exposed in the Prometheus text format.
+type counterVec struct {
+	name, help, label string
+
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+func newCounterVec(name, help, label string) *counterVec {
+	c := &counterVec{name: name, help: help, label: label, values: make(map[string]uint64)}
+	metrics = append(metrics, c)
+	return c
+}
+
+func (c *counterVec) inc(labelValue string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[labelValue]++
+}
+
+func (c *counterVec) get(labelValue string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[labelValue]
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", c.name, c.label, k, c.values[k])
+	}
+}
+
+// metrics lists every counter served by metricsHandler.
+var metrics []*counterVec
+
+func metricsHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, c := range metrics {
+		c.write(w)
+	}
+}
+
+var checkoutFailures = newCounterVec("checkout_failures_total", "PlaceOrder failures by failed step.", "reason")
+
+// failureLabels maps the ErrorInfo reasons of PlaceOrder errors to the
+// reason label of checkout_failures_total.
+var failureLabels = map[string]string{
+	reasonInvalidRequest:      "validation",
+	reasonCountryUnsupported:  "validation",
+	reasonCartTooLarge:        "validation",
+	reasonDuplicateCartItem:   "validation",
+	reasonCartUnavailable:     "cart",
+	reasonOrderIDFailed:       "prep",
+	reasonProductUnavailable:  "prep",
+	reasonProductNotFound:     "prep",
+	reasonTotalMismatch:       "prep",
+	reasonShippingQuoteFailed: "shipping-quote",
+	reasonCurrencyUnavailable: "conversion",
+	reasonCurrencyMismatch:    "conversion",
+	reasonTooManyConversions:  "conversion",
+	reasonTokenizationFailed:  "charge",
+	reasonBelowMinimumCharge:  "charge",
+	reasonPaymentDeclined:     "charge",
+	reasonPaymentUnavailable:  "charge",
+	reasonShippingUnavailable: "ship",
+}
+
+// failureLabel returns the checkout_failures_total reason of a PlaceOrder
+// error, "other" for errors without a known ErrorInfo reason.
+func failureLabel(err error) string {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			if l, ok := failureLabels[info.GetReason()]; ok {
+				return l
+			}
+		}
+	}
+	return "other"
+}
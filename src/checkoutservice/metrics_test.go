@@ -0,0 +1,76 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPlaceOrderCountsFailureReason(t *testing.T) {
+	f := newFakeDownstreams()
+	f.chargeErr = status.Error(codes.InvalidArgument, "card expired")
+	cs := newTestCheckoutService(t, f)
+	before := checkoutFailures.get("charge")
+
+	if _, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest()); err == nil {
+		t.Fatal("PlaceOrder() succeeded, want a payment failure")
+	}
+	if got := checkoutFailures.get("charge") - before; got != 1 {
+		t.Errorf("checkout_failures_total{reason=\"charge\"} increased by %d, want 1", got)
+	}
+}
+
+func TestFailureLabel(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{orderError(codes.InvalidArgument, reasonInvalidRequest, "bad"), "validation"},
+		{orderError(codes.Unavailable, reasonShippingQuoteFailed, "down"), "shipping-quote"},
+		{orderError(codes.Unavailable, reasonShippingUnavailable, "down"), "ship"},
+		{status.Error(codes.Internal, "no details"), "other"},
+	}
+	for _, tt := range tests {
+		if got := failureLabel(tt.err); got != tt.want {
+			t.Errorf("failureLabel(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	c := &counterVec{name: "test_total", help: "Test counter.", label: "kind", values: make(map[string]uint64)}
+	c.inc("b")
+	c.inc("a")
+	c.inc("b")
+	var sb strings.Builder
+	c.write(&sb)
+	want := "# HELP test_total Test counter.\n# TYPE test_total counter\ntest_total{kind=\"a\"} 1\ntest_total{kind=\"b\"} 2\n"
+	if sb.String() != want {
+		t.Errorf("write() = %q, want %q", sb.String(), want)
+	}
+
+	rec := httptest.NewRecorder()
+	new(checkoutService).debugHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "# TYPE checkout_failures_total counter") {
+		t.Errorf("GET /metrics = %d %q, want checkout_failures_total", rec.Code, rec.Body.String())
+	}
+}
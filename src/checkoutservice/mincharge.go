@@ -0,0 +1,54 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/money"
+)
+
+// minChargesFromEnv reads MIN_CHARGE_AMOUNTS, a comma-separated list of
+// CUR=amount entries such as "USD=0.50,JPY=50".
+func minChargesFromEnv() map[string]pb.Money {
+	out := make(map[string]pb.Money)
+	for _, kv := range listFromEnv("MIN_CHARGE_AMOUNTS") {
+		cur, v, ok := strings.Cut(kv, "=")
+		cur = strings.ToUpper(strings.TrimSpace(cur))
+		m, err := money.Parse(v, cur)
+		if !ok || cur == "" || err != nil || money.IsNegative(m) {
+			panic(fmt.Sprintf("environment variable \"MIN_CHARGE_AMOUNTS\" has invalid entry %q, want CUR=amount", kv))
+		}
+		out[cur] = m
+	}
+	return out
+}
+
+// belowMinimumCharge reports whether total is less than the minimum charge
+// configured for its currency, and returns that minimum.
+func (cs *checkoutService) belowMinimumCharge(total pb.Money) (pb.Money, bool) {
+	min, ok := cs.minCharges[total.GetCurrencyCode()]
+	if !ok {
+		return pb.Money{}, false
+	}
+	diff, err := money.Subtract(total, min)
+	if err != nil {
+		log.Warnf("failed to compare the total against the minimum charge: %v", err)
+		return min, false
+	}
+	return min, money.IsNegative(diff)
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPlaceOrderMinimumCharge(t *testing.T) {
+	// The test order totals 54.47 in whichever currency it is placed in.
+	tests := []struct {
+		name       string
+		currency   string
+		minCharges map[string]pb.Money
+		wantReject bool
+	}{
+		{"no minimums", "USD", nil, false},
+		{"above minimum", "USD", map[string]pb.Money{"USD": {CurrencyCode: "USD", Units: 50}}, false},
+		{"exactly minimum", "USD", map[string]pb.Money{"USD": {CurrencyCode: "USD", Units: 54, Nanos: 470000000}}, false},
+		{"below minimum", "USD", map[string]pb.Money{"USD": {CurrencyCode: "USD", Units: 100}}, true},
+		{"below minimum by less than a unit", "USD", map[string]pb.Money{"USD": {CurrencyCode: "USD", Units: 54, Nanos: 500000000}}, true},
+		{"above minimum by less than a unit", "USD", map[string]pb.Money{"USD": {CurrencyCode: "USD", Units: 54, Nanos: 400000000}}, false},
+		{"other currency's minimum", "EUR", map[string]pb.Money{"USD": {CurrencyCode: "USD", Units: 100}}, false},
+		{"below currency minimum", "EUR", map[string]pb.Money{
+			"USD": {CurrencyCode: "USD", Units: 50},
+			"EUR": {CurrencyCode: "EUR", Units: 60},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeDownstreams()
+			cs := newTestCheckoutService(t, f)
+			cs.minCharges = tt.minCharges
+
+			req := testPlaceOrderRequest()
+			req.UserCurrency = tt.currency
+			_, err := cs.PlaceOrder(context.Background(), req)
+			if !tt.wantReject {
+				if err != nil {
+					t.Fatalf("PlaceOrder() error = %v", err)
+				}
+				return
+			}
+			if status.Code(err) != codes.FailedPrecondition || failureLabel(err) != "charge" {
+				t.Errorf("PlaceOrder() error = %v, want a below-minimum rejection", err)
+			}
+			if f.chargeCalls != 0 {
+				t.Errorf("Charge called %d times below the minimum, want 0", f.chargeCalls)
+			}
+		})
+	}
+}
+
+func TestMinChargesFromEnv(t *testing.T) {
+	t.Setenv("MIN_CHARGE_AMOUNTS", "usd=0.50, JPY=50")
+	got := minChargesFromEnv()
+	if len(got) != 2 || got["USD"].Nanos != 500000000 || got["JPY"].Units != 50 || got["JPY"].CurrencyCode != "JPY" {
+		t.Errorf("minChargesFromEnv() = %v", got)
+	}
+
+	t.Setenv("MIN_CHARGE_AMOUNTS", "USD=-1")
+	defer func() {
+		if recover() == nil {
+			t.Error("minChargesFromEnv() did not panic on a negative minimum")
+		}
+	}()
+	minChargesFromEnv()
+}
@@ -0,0 +1,122 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package money
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+)
+
+type currencyFormat struct {
+	symbol   string
+	decimals int
+}
+
+// currencyFormats holds the display conventions of well-known currencies.
+var currencyFormats = map[string]currencyFormat{
+	"USD": {"$", 2},
+	"CAD": {"CA$", 2},
+	"EUR": {"€", 2},
+	"GBP": {"£", 2},
+	"JPY": {"¥", 0},
+	"KRW": {"₩", 0},
+	"TRY": {"₺", 2},
+}
+
+const defaultDecimals = 2
+
+// Format renders m with full nano precision followed by its currency code,
+// e.g. "-12.500000000 USD". It is meant for logs, not for display.
+func Format(m pb.Money) string {
+	sign, units, nanos := abs(m)
+	return fmt.Sprintf("%s%d.%09d %s", sign, units, nanos, m.GetCurrencyCode())
+}
+
+// Parse parses a decimal amount such as "12.5" or "-0.000000001" with at
+// most nine decimals into a Money value in currencyCode.
+func Parse(amount, currencyCode string) (pb.Money, error) {
+	s := strings.TrimSpace(amount)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" || len(frac) > 9 || strings.ContainsAny(whole+frac, "+-") {
+		return pb.Money{}, fmt.Errorf("invalid amount %q", amount)
+	}
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return pb.Money{}, fmt.Errorf("invalid amount %q", amount)
+	}
+	var nanos int64
+	if frac != "" {
+		if nanos, err = strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 32); err != nil {
+			return pb.Money{}, fmt.Errorf("invalid amount %q", amount)
+		}
+	}
+	if negative {
+		units, nanos = -units, -nanos
+	}
+	return pb.Money{CurrencyCode: currencyCode, Units: units, Nanos: int32(nanos)}, nil
+}
+
+// FormatLocalized renders m for display using its currency's symbol and
+// number of decimal places, with digits grouped by thousands, e.g.
+// "$1,234.56" or "¥1,234". Currencies without a known convention get two
+// decimals followed by the currency code. Values are rounded half up.
+func FormatLocalized(m pb.Money) string {
+	f, known := currencyFormats[m.GetCurrencyCode()]
+	if !known {
+		f = currencyFormat{decimals: defaultDecimals}
+	}
+	sign, units, nanos := abs(Round(m, RoundHalfUp))
+
+	scale := int32(1)
+	for i := 0; i < 9-f.decimals; i++ {
+		scale *= 10
+	}
+	frac := nanos / scale
+
+	out := sign + f.symbol + groupThousands(units)
+	if f.decimals > 0 {
+		out += fmt.Sprintf(".%0*d", f.decimals, frac)
+	}
+	if !known {
+		out += " " + m.GetCurrencyCode()
+	}
+	return out
+}
+
+// abs returns the sign prefix and absolute units and nanos of m.
+func abs(m pb.Money) (string, int64, int32) {
+	units, nanos := m.GetUnits(), m.GetNanos()
+	if units < 0 || nanos < 0 {
+		return "-", -units, -nanos
+	}
+	return "", units, nanos
+}
+
+func groupThousands(n int64) string {
+	s := fmt.Sprint(n)
+	var b strings.Builder
+	for i, c := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
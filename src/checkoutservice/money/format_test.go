@@ -0,0 +1,89 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package money
+
+import (
+	"testing"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		in   pb.Money
+		want string
+	}{
+		{mmc(54, 470000000, "USD"), "54.470000000 USD"},
+		{mmc(-12, -500000000, "EUR"), "-12.500000000 EUR"},
+		{mmc(0, 1, "JPY"), "0.000000001 JPY"},
+	}
+	for _, tt := range tests {
+		if got := Format(tt.in); got != tt.want {
+			t.Errorf("Format(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatLocalized(t *testing.T) {
+	tests := []struct {
+		name string
+		in   pb.Money
+		want string
+	}{
+		{"USD", mmc(1234, 560000000, "USD"), "$1,234.56"},
+		{"USD millions", mmc(1234567, 0, "USD"), "$1,234,567.00"},
+		{"USD rounds half up", mmc(0, 995000000, "USD"), "$1.00"},
+		{"USD negative", mmc(-5, -250000000, "USD"), "-$5.25"},
+		{"JPY has no decimals", mmc(1234, 0, "JPY"), "¥1,234"},
+		{"JPY rounds", mmc(1233, 600000000, "JPY"), "¥1,234"},
+		{"unknown currency", mmc(1234, 567000000, "XYZ"), "1,234.57 XYZ"},
+		{"small", mmc(999, 0, "EUR"), "€999.00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FormatLocalized(tt.in); got != tt.want {
+				t.Errorf("FormatLocalized(%v) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    pb.Money
+		wantErr bool
+	}{
+		{"12", mmc(12, 0, "USD"), false},
+		{"0.50", mmc(0, 500000000, "USD"), false},
+		{"-1.000000001", mmc(-1, -1, "USD"), false},
+		{" 3.25 ", mmc(3, 250000000, "USD"), false},
+		{"", pb.Money{}, true},
+		{".5", pb.Money{}, true},
+		{"1.0000000001", pb.Money{}, true},
+		{"1.-5", pb.Money{}, true},
+		{"abc", pb.Money{}, true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in, "USD")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !AreEquals(got, tt.want) {
+			t.Errorf("Parse(%q) = %v, want %v", tt.in, Format(got), Format(tt.want))
+		}
+	}
+}
@@ -16,6 +16,7 @@ package money
 
 import (
 	"errors"
+	"math/big"
 
 	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
 )
@@ -120,6 +121,19 @@ func Sum(l, r pb.Money) (pb.Money, error) {
 		CurrencyCode: l.GetCurrencyCode()}, nil
 }
 
+// Subtract returns l minus r. Unlike Sum(l, Negate(r)) it also handles
+// differences smaller than one unit between values of opposite sign. Returns
+// an error if one of the values are invalid or currency codes are not
+// matching (unless currency code is unspecified for both).
+func Subtract(l, r pb.Money) (pb.Money, error) {
+	if !IsValid(l) || !IsValid(r) {
+		return pb.Money{}, ErrInvalidValue
+	} else if l.GetCurrencyCode() != r.GetCurrencyCode() {
+		return pb.Money{}, ErrMismatchingCurrency
+	}
+	return fromTotalNanos(new(big.Int).Sub(totalNanos(l), totalNanos(r)), l.GetCurrencyCode())
+}
+
 // MultiplySlow is a slow multiplication operation done through adding the value
 // to itself n-1 times.
 func MultiplySlow(m pb.Money, n uint32) pb.Money {
@@ -130,3 +144,45 @@ func MultiplySlow(m pb.Money, n uint32) pb.Money {
 	}
 	return out
 }
+
+// MultiplyRate converts m using rate, the value of one unit of m's currency
+// expressed in the target currency. The result carries rate's currency code
+// and is truncated towards zero to nano precision. Returns an error if one of
+// the values is invalid.
+func MultiplyRate(m, rate pb.Money) (pb.Money, error) {
+	if !IsValid(m) || !IsValid(rate) {
+		return pb.Money{}, ErrInvalidValue
+	}
+	nanos := new(big.Int).Mul(totalNanos(m), totalNanos(rate))
+	nanos.Quo(nanos, big.NewInt(nanosMod))
+	return fromTotalNanos(nanos, rate.GetCurrencyCode())
+}
+
+// Rate returns the value of one unit of from's currency in to's currency,
+// given that from converts to to. The result is truncated to nano precision
+// and can be passed to MultiplyRate. Returns an error if one of the values is
+// invalid or from is zero.
+func Rate(from, to pb.Money) (pb.Money, error) {
+	if !IsValid(from) || !IsValid(to) || IsZero(from) {
+		return pb.Money{}, ErrInvalidValue
+	}
+	nanos := new(big.Int).Mul(totalNanos(to), big.NewInt(nanosMod))
+	nanos.Quo(nanos, totalNanos(from))
+	return fromTotalNanos(nanos, to.GetCurrencyCode())
+}
+
+func totalNanos(m pb.Money) *big.Int {
+	n := new(big.Int).Mul(big.NewInt(m.GetUnits()), big.NewInt(nanosMod))
+	return n.Add(n, big.NewInt(int64(m.GetNanos())))
+}
+
+func fromTotalNanos(n *big.Int, currencyCode string) (pb.Money, error) {
+	units, nanos := new(big.Int).QuoRem(n, big.NewInt(nanosMod), new(big.Int))
+	if !units.IsInt64() {
+		return pb.Money{}, ErrInvalidValue
+	}
+	return pb.Money{
+		Units:        units.Int64(),
+		Nanos:        int32(nanos.Int64()),
+		CurrencyCode: currencyCode}, nil
+}
@@ -243,3 +243,88 @@ func TestSum(t *testing.T) {
 		})
 	}
 }
+
+func TestSubtract(t *testing.T) {
+	tests := []struct {
+		name    string
+		l, r    pb.Money
+		want    pb.Money
+		wantErr error
+	}{
+		{"0-0=0", mm(0, 0), mm(0, 0), mm(0, 0), nil},
+		{"positive result", mm(5, 500000000), mm(2, 700000000), mm(2, 800000000), nil},
+		{"negative result", mm(2, 700000000), mm(5, 500000000), mm(-2, -800000000), nil},
+		{"below one unit", mm(0, 300000000), mm(0, 500000000), mm(0, -200000000), nil},
+		{"below one unit with units", mm(49, 300000000), mm(49, 500000000), mm(0, -200000000), nil},
+		{"leaves less than one unit", mm(54, 470000000), mm(54, 0), mm(0, 470000000), nil},
+		{"equal", mmc(54, 470000000, "USD"), mmc(54, 470000000, "USD"), mmc(0, 0, "USD"), nil},
+		{"negative operand", mm(1, 0), mm(-1, -500000000), mm(2, 500000000), nil},
+		{"Error: currency code mismatch", mmc(1, 0, "AAA"), mmc(1, 0, "BBB"), pb.Money{}, ErrMismatchingCurrency},
+		{"Error: invalid", mm(1, -1), mm(0, 0), pb.Money{}, ErrInvalidValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Subtract(tt.l, tt.r)
+			if err != tt.wantErr {
+				t.Fatalf("Subtract(%v, %v) error = %v, want %v", tt.l, tt.r, err, tt.wantErr)
+			}
+			if !AreEquals(got, tt.want) || !IsValid(got) {
+				t.Errorf("Subtract(%v, %v) = %v, want %v", tt.l, tt.r, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultiplyRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		m, rate pb.Money
+		want    pb.Money
+		wantErr error
+	}{
+		{"identity", mmc(12, 340000000, "USD"), mmc(1, 0, "USD"), mmc(12, 340000000, "USD"), nil},
+		{"whole rate", mmc(10, 500000000, "USD"), mmc(2, 0, "EUR"), mmc(21, 0, "EUR"), nil},
+		{"fractional rate", mmc(10, 0, "USD"), mmc(0, 912345678, "EUR"), mmc(9, 123456780, "EUR"), nil},
+		{"truncates sub-nanos", mmc(0, 1, "USD"), mmc(0, 500000000, "EUR"), mmc(0, 0, "EUR"), nil},
+		{"negative", mmc(-3, -500000000, "USD"), mmc(2, 0, "EUR"), mmc(-7, 0, "EUR"), nil},
+		{"large", mmc(1000000000, 0, "JPY"), mmc(0, 6700000, "USD"), mmc(6700000, 0, "USD"), nil},
+		{"invalid", mm(1, -1), mmc(1, 0, "EUR"), pb.Money{}, ErrInvalidValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MultiplyRate(tt.m, tt.rate)
+			if err != tt.wantErr {
+				t.Fatalf("MultiplyRate(%v, %v) error = %v, want %v", tt.m, tt.rate, err, tt.wantErr)
+			}
+			if !AreEquals(got, tt.want) {
+				t.Errorf("MultiplyRate(%v, %v) = %v, want %v", tt.m, tt.rate, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRate(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to pb.Money
+		want     pb.Money
+		wantErr  error
+	}{
+		{"identity", mmc(12, 340000000, "USD"), mmc(12, 340000000, "USD"), mmc(1, 0, "USD"), nil},
+		{"double", mmc(10, 500000000, "USD"), mmc(21, 0, "EUR"), mmc(2, 0, "EUR"), nil},
+		{"fractional", mmc(10, 0, "USD"), mmc(9, 123456780, "EUR"), mmc(0, 912345678, "EUR"), nil},
+		{"zero from", mmc(0, 0, "USD"), mmc(1, 0, "EUR"), pb.Money{}, ErrInvalidValue},
+		{"invalid", mm(1, -1), mmc(1, 0, "EUR"), pb.Money{}, ErrInvalidValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Rate(tt.from, tt.to)
+			if err != tt.wantErr {
+				t.Fatalf("Rate(%v, %v) error = %v, want %v", tt.from, tt.to, err, tt.wantErr)
+			}
+			if !AreEquals(got, tt.want) {
+				t.Errorf("Rate(%v, %v) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package money
+
+import (
+	"fmt"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+)
+
+// RoundingMode selects how Round breaks ties.
+type RoundingMode int
+
+const (
+	// RoundHalfUp rounds ties away from zero.
+	RoundHalfUp RoundingMode = iota
+	// RoundHalfEven rounds ties to the nearest even minor unit.
+	RoundHalfEven
+)
+
+// ParseRoundingMode parses "half-up" or "half-even".
+func ParseRoundingMode(s string) (RoundingMode, error) {
+	switch s {
+	case "half-up":
+		return RoundHalfUp, nil
+	case "half-even":
+		return RoundHalfEven, nil
+	}
+	return 0, fmt.Errorf("unknown rounding mode %q", s)
+}
+
+// MinorUnitDecimals returns the number of decimal places of the minor unit of
+// currencyCode, e.g. 2 for USD and 0 for JPY.
+func MinorUnitDecimals(currencyCode string) int {
+	if f, ok := currencyFormats[currencyCode]; ok {
+		return f.decimals
+	}
+	return defaultDecimals
+}
+
+// Round rounds m to the minor unit of its currency, breaking ties according
+// to mode.
+func Round(m pb.Money, mode RoundingMode) pb.Money {
+	decimals := MinorUnitDecimals(m.GetCurrencyCode())
+	scale := int64(1)
+	for i := 0; i < 9-decimals; i++ {
+		scale *= 10
+	}
+	negative := IsNegative(m)
+	units, nanos := m.GetUnits(), int64(m.GetNanos())
+	if negative {
+		units, nanos = -units, -nanos
+	}
+
+	q, r := nanos/scale, nanos%scale
+	last := q
+	if decimals == 0 {
+		last = units
+	}
+	if r > scale/2 || (r == scale/2 && (mode == RoundHalfUp || last%2 == 1)) {
+		q++
+	}
+	nanos = q * scale
+	if nanos == nanosMod {
+		units, nanos = units+1, 0
+	}
+
+	if negative {
+		units, nanos = -units, -nanos
+	}
+	return pb.Money{CurrencyCode: m.GetCurrencyCode(), Units: units, Nanos: int32(nanos)}
+}
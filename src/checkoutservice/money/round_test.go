@@ -0,0 +1,56 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package money
+
+import (
+	"testing"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+)
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		in   pb.Money
+		mode RoundingMode
+		want pb.Money
+	}{
+		{mmc(1, 234000000, "USD"), RoundHalfUp, mmc(1, 230000000, "USD")},
+		{mmc(1, 236000000, "USD"), RoundHalfUp, mmc(1, 240000000, "USD")},
+		{mmc(1, 225000000, "USD"), RoundHalfUp, mmc(1, 230000000, "USD")},
+		{mmc(1, 225000000, "USD"), RoundHalfEven, mmc(1, 220000000, "USD")},
+		{mmc(1, 235000000, "USD"), RoundHalfEven, mmc(1, 240000000, "USD")},
+		{mmc(1, 995000000, "USD"), RoundHalfUp, mmc(2, 0, "USD")},
+		{mmc(-1, -225000000, "USD"), RoundHalfUp, mmc(-1, -230000000, "USD")},
+		{mmc(-1, -225000000, "USD"), RoundHalfEven, mmc(-1, -220000000, "USD")},
+		{mmc(2, 500000000, "JPY"), RoundHalfUp, mmc(3, 0, "JPY")},
+		{mmc(2, 500000000, "JPY"), RoundHalfEven, mmc(2, 0, "JPY")},
+		{mmc(3, 500000000, "JPY"), RoundHalfEven, mmc(4, 0, "JPY")},
+		{mmc(0, 5000000, "XYZ"), RoundHalfEven, mmc(0, 0, "XYZ")},
+	}
+	for _, tt := range tests {
+		if got := Round(tt.in, tt.mode); !AreEquals(got, tt.want) {
+			t.Errorf("Round(%v, %v) = %v, want %v", Format(tt.in), tt.mode, Format(got), Format(tt.want))
+		}
+	}
+}
+
+func TestParseRoundingMode(t *testing.T) {
+	if m, err := ParseRoundingMode("half-even"); err != nil || m != RoundHalfEven {
+		t.Errorf(`ParseRoundingMode("half-even") = %v, %v`, m, err)
+	}
+	if _, err := ParseRoundingMode("bankers"); err == nil {
+		t.Error(`ParseRoundingMode("bankers") succeeded, want error`)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// idempotencyKeyHeader is the request metadata key clients set to make
+	// deterministic order IDs stable across retries of the same order.
+	idempotencyKeyHeader = "idempotency-key"
+	// orderNumberHeader is the response metadata key carrying the order
+	// number when order numbers are enabled.
+	orderNumberHeader = "order-number"
+
+	defaultOrderNumberDigits = 6
+)
+
+// defaultOrderIDNamespace is used for deterministic order IDs when
+// ORDER_ID_NAMESPACE is not set.
+var defaultOrderIDNamespace = uuid.NewSHA1(uuid.NameSpaceDNS, []byte("checkoutservice.hipstershop"))
+
+// orderIDNamespaceFromEnv returns the namespace for deterministic (v5) order
+// IDs when ORDER_ID_VERSION=5, or uuid.Nil to keep time-based (v1) IDs.
+func orderIDNamespaceFromEnv() uuid.UUID {
+	switch v := stringFromEnv("ORDER_ID_VERSION", "1"); v {
+	case "1":
+		return uuid.Nil
+	case "5":
+	default:
+		panic(fmt.Sprintf("environment variable \"ORDER_ID_VERSION\" must be 1 or 5, got %q", v))
+	}
+	ns := stringFromEnv("ORDER_ID_NAMESPACE", defaultOrderIDNamespace.String())
+	id, err := uuid.Parse(ns)
+	if err != nil {
+		panic(fmt.Sprintf("environment variable \"ORDER_ID_NAMESPACE\" is not a valid uuid: %v", err))
+	}
+	return id
+}
+
+// newOrderID returns a v5 uuid derived from the user id and the request's
+// idempotency key when deterministic IDs are enabled and a key was sent, and a
+// v1 uuid otherwise.
+func (cs *checkoutService) newOrderID(ctx context.Context, userID string) (uuid.UUID, error) {
+	if cs.orderIDNamespace == uuid.Nil {
+		return uuid.NewUUID()
+	}
+	key := idempotencyKey(ctx)
+	if key == "" {
+		log.Debugf("no %s metadata sent, using a time-based order id", idempotencyKeyHeader)
+		return uuid.NewUUID()
+	}
+	return deterministicOrderID(cs.orderIDNamespace, userID, key), nil
+}
+
+func deterministicOrderID(namespace uuid.UUID, userID, key string) uuid.UUID {
+	// The separator keeps ("ab", "c") and ("a", "bc") from colliding.
+	return uuid.NewSHA1(namespace, []byte(userID+"\x00"+key))
+}
+
+func idempotencyKey(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if v := md.Get(idempotencyKeyHeader); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// orderNumbers allocates human-friendly order numbers such as
+// "OB-2024-000123" alongside the order uuid. The last allocated number is
+// kept in a file and every allocation holds an exclusive lock on it, so the
+// sequence survives restarts and replicas sharing the file never hand out
+// the same number. The file must be on storage that supports flock(2).
+type orderNumbers struct {
+	prefix string
+	digits int
+	start  int64
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// orderNumbersFromEnv returns nil unless ORDER_NUMBER_PREFIX is set.
+func orderNumbersFromEnv() (*orderNumbers, error) {
+	prefix := stringFromEnv("ORDER_NUMBER_PREFIX", "")
+	if prefix == "" {
+		return nil, nil
+	}
+	digits := intFromEnv("ORDER_NUMBER_DIGITS", defaultOrderNumberDigits)
+	start := intFromEnv("ORDER_NUMBER_START", 1)
+	if digits < 1 || start < 1 {
+		panic("environment variables \"ORDER_NUMBER_DIGITS\" and \"ORDER_NUMBER_START\" must be at least 1")
+	}
+	path := stringFromEnv("ORDER_NUMBER_FILE", "")
+	if path == "" {
+		panic("environment variable \"ORDER_NUMBER_FILE\" must be set when \"ORDER_NUMBER_PREFIX\" is")
+	}
+	return newOrderNumbers(path, prefix, digits, int64(start))
+}
+
+// newOrderNumbers opens the sequence file at path, creating it if needed.
+// start is the first number handed out when the file is empty; a file that
+// already holds a number continues from it.
+func newOrderNumbers(path, prefix string, digits int, start int64) (*orderNumbers, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &orderNumbers{prefix: prefix, digits: digits, start: start, file: f}, nil
+}
+
+// next allocates the next number in the sequence and persists it before
+// returning. It is safe for concurrent use, also across processes sharing
+// the file, and never returns the same number twice.
+func (n *orderNumbers) next() (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	fd := int(n.file.Fd())
+	if err := syscall.Flock(fd, syscall.LOCK_EX); err != nil {
+		return "", fmt.Errorf("failed to lock order number file: %v", err)
+	}
+	defer syscall.Flock(fd, syscall.LOCK_UN)
+
+	last := n.start - 1
+	buf := make([]byte, 32)
+	k, err := n.file.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read order number file: %v", err)
+	}
+	if s := strings.TrimSpace(string(buf[:k])); s != "" {
+		if last, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return "", fmt.Errorf("order number file holds %q, not a number", s)
+		}
+	}
+	last++
+	// Overwrite before truncating: the new number is never shorter than
+	// the old one, so a crash in between leaves a file that still holds at
+	// least the old number rather than an empty one.
+	line := []byte(strconv.FormatInt(last, 10) + "\n")
+	if _, err := n.file.WriteAt(line, 0); err != nil {
+		return "", fmt.Errorf("failed to write order number file: %v", err)
+	}
+	if err := n.file.Truncate(int64(len(line))); err != nil {
+		return "", fmt.Errorf("failed to write order number file: %v", err)
+	}
+	if err := n.file.Sync(); err != nil {
+		return "", fmt.Errorf("failed to write order number file: %v", err)
+	}
+	return fmt.Sprintf("%s%0*d", n.prefix, n.digits, last), nil
+}
+
+func (n *orderNumbers) close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.file.Close()
+}
@@ -0,0 +1,210 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestNewOrderIDDeterministic(t *testing.T) {
+	cs := &checkoutService{orderIDNamespace: defaultOrderIDNamespace}
+	withKey := func(key string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(idempotencyKeyHeader, key))
+	}
+
+	a, _ := cs.newOrderID(withKey("k1"), "user-1")
+	b, _ := cs.newOrderID(withKey("k1"), "user-1")
+	if a != b {
+		t.Errorf("same inputs produced different ids: %v != %v", a, b)
+	}
+	if a.Version() != 5 {
+		t.Errorf("id version = %d, want 5", a.Version())
+	}
+
+	for _, other := range []struct{ user, key string }{{"user-2", "k1"}, {"user-1", "k2"}} {
+		c, _ := cs.newOrderID(withKey(other.key), other.user)
+		if c == a {
+			t.Errorf("(%q, %q) produced the same id as (user-1, k1)", other.user, other.key)
+		}
+	}
+}
+
+func TestNewOrderIDDefaultsToV1(t *testing.T) {
+	withKey := metadata.NewIncomingContext(context.Background(), metadata.Pairs(idempotencyKeyHeader, "k1"))
+	tests := []struct {
+		name string
+		ns   uuid.UUID
+		ctx  context.Context
+	}{
+		{"disabled", uuid.Nil, withKey},
+		{"no key", defaultOrderIDNamespace, context.Background()},
+	}
+	for _, tt := range tests {
+		cs := &checkoutService{orderIDNamespace: tt.ns}
+		id, err := cs.newOrderID(tt.ctx, "user-1")
+		if err != nil {
+			t.Fatalf("%s: newOrderID() error = %v", tt.name, err)
+		}
+		if id.Version() != 1 {
+			t.Errorf("%s: id version = %d, want 1", tt.name, id.Version())
+		}
+	}
+}
+
+// testOrderNumbers returns an order number sequence backed by a file in a
+// temporary directory.
+func testOrderNumbers(t *testing.T, prefix string, digits int, start int64) *orderNumbers {
+	t.Helper()
+	return openOrderNumbers(t, filepath.Join(t.TempDir(), "order-number"), prefix, digits, start)
+}
+
+func openOrderNumbers(t *testing.T, path, prefix string, digits int, start int64) *orderNumbers {
+	t.Helper()
+	n, err := newOrderNumbers(path, prefix, digits, start)
+	if err != nil {
+		t.Fatalf("newOrderNumbers() error = %v", err)
+	}
+	t.Cleanup(func() { n.close() })
+	return n
+}
+
+func mustNext(t *testing.T, n *orderNumbers) string {
+	t.Helper()
+	number, err := n.next()
+	if err != nil {
+		t.Fatalf("next() error = %v", err)
+	}
+	return number
+}
+
+func TestOrderNumbersFormat(t *testing.T) {
+	n := testOrderNumbers(t, "OB-2024-", 6, 123)
+	for _, want := range []string{"OB-2024-000123", "OB-2024-000124"} {
+		if got := mustNext(t, n); got != want {
+			t.Errorf("next() = %q, want %q", got, want)
+		}
+	}
+	if got := mustNext(t, testOrderNumbers(t, "X", 2, 100)); got != "X100" {
+		t.Errorf("next() past the padding = %q, want %q", got, "X100")
+	}
+}
+
+func TestOrderNumbersSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "order-number")
+	first := openOrderNumbers(t, path, "OB-", 3, 7)
+	mustNext(t, first)
+	mustNext(t, first)
+	first.close()
+
+	// ORDER_NUMBER_START only applies to an empty file.
+	if got := mustNext(t, openOrderNumbers(t, path, "OB-", 3, 1)); got != "OB-009" {
+		t.Errorf("next() after reopening = %q, want %q", got, "OB-009")
+	}
+}
+
+func TestOrderNumbersRewriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "order-number")
+	if err := os.WriteFile(path, []byte(" 41 \n\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := mustNext(t, openOrderNumbers(t, path, "OB-", 3, 1)); got != "OB-042" {
+		t.Errorf("next() = %q, want %q", got, "OB-042")
+	}
+	if b, _ := os.ReadFile(path); string(b) != "42\n" {
+		t.Errorf("order number file holds %q, want %q", b, "42\n")
+	}
+}
+
+func TestOrderNumbersCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "order-number")
+	if err := os.WriteFile(path, []byte("not a number"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openOrderNumbers(t, path, "OB-", 3, 1).next(); err == nil {
+		t.Error("next() on a corrupt file succeeded, want error")
+	}
+}
+
+func TestOrderNumbersConcurrent(t *testing.T) {
+	const calls = 200
+	// Two instances on the same file stand in for two replicas.
+	path := filepath.Join(t.TempDir(), "order-number")
+	replicas := []*orderNumbers{
+		openOrderNumbers(t, path, "OB-", 4, 1),
+		openOrderNumbers(t, path, "OB-", 4, 1),
+	}
+	got := make(chan string, calls)
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func(n *orderNumbers) {
+			defer wg.Done()
+			number, err := n.next()
+			if err != nil {
+				t.Errorf("next() error = %v", err)
+			}
+			got <- number
+		}(replicas[i%len(replicas)])
+	}
+	wg.Wait()
+	close(got)
+
+	seen := make(map[string]bool, calls)
+	for number := range got {
+		if seen[number] {
+			t.Fatalf("order number %q allocated twice", number)
+		}
+		seen[number] = true
+	}
+	if !seen["OB-0001"] || !seen["OB-0200"] || mustNext(t, replicas[0]) != "OB-0201" {
+		t.Errorf("allocated %d numbers, want OB-0001 through OB-0200 with no gaps", len(seen))
+	}
+}
+
+// headerCapture records the response headers a handler sets.
+type headerCapture struct {
+	grpc.ServerTransportStream
+	header metadata.MD
+}
+
+func (h *headerCapture) SetHeader(md metadata.MD) error {
+	h.header = metadata.Join(h.header, md)
+	return nil
+}
+
+func TestPlaceOrderOrderNumberHeader(t *testing.T) {
+	f := newFakeDownstreams()
+	cs := newTestCheckoutService(t, f)
+	cs.orderNumbers = testOrderNumbers(t, "OB-", 3, 7)
+
+	for _, want := range []string{"OB-007", "OB-008"} {
+		stream := &headerCapture{}
+		ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+		if _, err := cs.PlaceOrder(ctx, testPlaceOrderRequest()); err != nil {
+			t.Fatalf("PlaceOrder() error = %v", err)
+		}
+		if got := stream.header.Get(orderNumberHeader); len(got) != 1 || got[0] != want {
+			t.Errorf("%s header = %v, want %q", orderNumberHeader, got, want)
+		}
+	}
+}
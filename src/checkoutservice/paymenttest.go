@@ -0,0 +1,83 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+)
+
+// testDeclineCardNumber is declined without calling the payment service when
+// PAYMENT_TEST_DECLINE=card.
+const testDeclineCardNumber = "4000000000000002"
+
+// paymentTestDecline forces payment declines for demos and chaos testing.
+type paymentTestDecline int
+
+const (
+	// declineNone charges every card normally.
+	declineNone paymentTestDecline = iota
+	// declineTestCard declines testDeclineCardNumber only.
+	declineTestCard
+	// declineAll declines every card.
+	declineAll
+)
+
+// paymentTestDeclineFromEnv reads PAYMENT_TEST_DECLINE, which is either
+// unset, "card" or "all".
+func paymentTestDeclineFromEnv() paymentTestDecline {
+	v := stringFromEnv("PAYMENT_TEST_DECLINE", "")
+	d, ok := parsePaymentTestDecline(v)
+	if !ok {
+		panic(fmt.Sprintf("environment variable \"PAYMENT_TEST_DECLINE\" is %q, want \"card\" or \"all\"", v))
+	}
+	return d
+}
+
+// parsePaymentTestDecline parses the names returned by String. An empty
+// string is the same as "off".
+func parsePaymentTestDecline(s string) (paymentTestDecline, bool) {
+	switch s {
+	case "", "off":
+		return declineNone, true
+	case "card":
+		return declineTestCard, true
+	case "all":
+		return declineAll, true
+	}
+	return declineNone, false
+}
+
+func (d paymentTestDecline) String() string {
+	switch d {
+	case declineTestCard:
+		return "card"
+	case declineAll:
+		return "all"
+	}
+	return "off"
+}
+
+func (d paymentTestDecline) declines(card *pb.CreditCardInfo) bool {
+	switch d {
+	case declineAll:
+		return true
+	case declineTestCard:
+		return strings.NewReplacer(" ", "", "-", "").Replace(card.GetCreditCardNumber()) == testDeclineCardNumber
+	}
+	return false
+}
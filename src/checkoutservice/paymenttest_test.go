@@ -0,0 +1,63 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPlaceOrderPaymentTestDecline(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        paymentTestDecline
+		card        string
+		wantDecline bool
+	}{
+		{"off", declineNone, testDeclineCardNumber, false},
+		{"test card", declineTestCard, "4000-0000-0000-0002", true},
+		{"other card", declineTestCard, "4432-8015-6152-0454", false},
+		{"all", declineAll, "4432-8015-6152-0454", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeDownstreams()
+			cs := newTestCheckoutService(t, f)
+			cs.flags.setPaymentTestDecline(tt.mode)
+
+			req := testPlaceOrderRequest()
+			req.CreditCard.CreditCardNumber = tt.card
+			_, err := cs.PlaceOrder(context.Background(), req)
+			if !tt.wantDecline {
+				if err != nil {
+					t.Fatalf("PlaceOrder() error = %v", err)
+				}
+				if f.chargeCalls != 1 {
+					t.Errorf("Charge called %d times, want 1", f.chargeCalls)
+				}
+				return
+			}
+			if status.Code(err) != codes.FailedPrecondition || failureLabel(err) != "charge" {
+				t.Errorf("PlaceOrder() error = %v, want a declined payment", err)
+			}
+			if f.chargeCalls != 0 {
+				t.Errorf("Charge called %d times for a test decline, want 0", f.chargeCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/status"
+)
+
+// callPolicy is the timeout and retry policy of a downstream call.
+type callPolicy struct {
+	// timeout bounds each attempt. Zero leaves only the request's deadline.
+	timeout time.Duration
+	// attempts is the number of tries, including the first.
+	attempts int
+	backoff  backoff
+}
+
+// callDependencies maps the calls made through callWithPolicy to the
+// dependency whose policy applies to them.
+var callDependencies = map[string]string{
+	"getUserCart":           "cart",
+	"emptyCart":             "cart",
+	"quoteShipping":         "shipping",
+	"shipOrder":             "shipping",
+	"convertCurrency":       "currency",
+	"chargeCard":            "payment",
+	"listProducts":          "catalog",
+	"getProduct":            "catalog",
+	"sendOrderConfirmation": "email",
+}
+
+// nonIdempotentCalls are tried once whatever their policy, since a failed
+// attempt may still have taken effect.
+var nonIdempotentCalls = map[string]bool{
+	"chargeCard": true,
+	"shipOrder":  true,
+}
+
+var defaultCallBackoff = backoff{initial: retryBaseDelay, max: time.Second}
+
+// defaultCallPolicy is the policy of dependency before configuration. Order
+// confirmations are retried at most once, every other call up to
+// maxAttemptsPerCall times.
+func defaultCallPolicy(dependency string) callPolicy {
+	p := callPolicy{attempts: maxAttemptsPerCall, backoff: defaultCallBackoff}
+	if dependency == "email" {
+		p.attempts = 2
+	}
+	return p
+}
+
+// callPoliciesFromEnv reads CALL_TIMEOUT, CALL_ATTEMPTS and CALL_BACKOFF,
+// which apply to every dependency, and their per-dependency overrides such
+// as PAYMENT_CALL_TIMEOUT.
+func callPoliciesFromEnv() map[string]callPolicy {
+	out := make(map[string]callPolicy)
+	for _, dep := range callDependencies {
+		p := defaultCallPolicy(dep)
+		prefix := strings.ToUpper(dep) + "_"
+		p.timeout = durationFromEnv(prefix+"CALL_TIMEOUT", durationFromEnv("CALL_TIMEOUT", p.timeout))
+		p.attempts = intFromEnv(prefix+"CALL_ATTEMPTS", intFromEnv("CALL_ATTEMPTS", p.attempts))
+		p.backoff.initial = durationFromEnv(prefix+"CALL_BACKOFF", durationFromEnv("CALL_BACKOFF", p.backoff.initial))
+		out[dep] = p
+	}
+	return out
+}
+
+func (cs *checkoutService) callPolicy(name string) callPolicy {
+	dep := callDependencies[name]
+	if p, ok := cs.callPolicies[dep]; ok {
+		return p
+	}
+	return defaultCallPolicy(dep)
+}
+
+var downstreamRetries = newCounterVec("checkout_downstream_retries_total", "Downstream calls retried by callWithPolicy.", "call")
+
+// callWithPolicy runs fn under the policy of the named call: each attempt is
+// bounded by the policy's timeout, and attempts failing with a transient
+// code or timing out are retried with backoff, up to the policy's attempts.
+// When ctx carries a retry budget every retry also takes a token from it,
+// and once it is spent calls are no longer retried. Retries are counted in
+// downstreamRetries and added as events to the span in ctx.
+func (cs *checkoutService) callWithPolicy(ctx context.Context, name string, fn func(context.Context) error) error {
+	p := cs.callPolicy(name)
+	if nonIdempotentCalls[name] {
+		p.attempts = 1
+	}
+	var wait time.Duration
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if p.timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, p.timeout)
+		}
+		err := fn(attemptCtx)
+		timedOut := attemptCtx.Err() == context.DeadlineExceeded
+		cancel()
+		if err == nil || attempt >= p.attempts || ctx.Err() != nil || !(isRetryable(err) || timedOut) {
+			return err
+		}
+		if budget := retryBudgetFrom(ctx); budget != nil && !budget.take() {
+			log.Debugf("retry budget exhausted, not retrying %s: %v", name, err)
+			return err
+		}
+
+		wait = p.backoff.next(wait)
+		downstreamRetries.inc(name)
+		trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(
+			attribute.Int("attempt", attempt+1),
+			attribute.String("code", status.Code(err).String())))
+		log.Debugf("retrying %s (attempt %d) in %v: %v", name, attempt+1, wait, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCallWithPolicy(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "restarting")
+	tests := []struct {
+		name      string
+		call      string
+		policy    callPolicy
+		errs      []error // returned by successive attempts; nil afterwards
+		block     bool    // attempts wait for their context instead
+		budget    int     // retry budget; -1 for none
+		wantCalls int
+		wantErr   bool
+	}{
+		{"success", "getUserCart", callPolicy{attempts: 3}, nil, false, 10, 1, false},
+		{"retry then success", "getUserCart", callPolicy{attempts: 3}, []error{unavailable}, false, 10, 2, false},
+		{"retries exhausted", "getUserCart", callPolicy{attempts: 2}, []error{unavailable, unavailable, unavailable}, false, 10, 2, true},
+		{"budget exhausted", "getUserCart", callPolicy{attempts: 3}, []error{unavailable, unavailable, unavailable}, false, 1, 2, true},
+		{"zero budget", "getUserCart", callPolicy{attempts: 3}, []error{unavailable}, false, 0, 1, true},
+		{"no budget", "getUserCart", callPolicy{attempts: 3}, []error{unavailable}, false, -1, 2, false},
+		{"no budget, retries exhausted", "getUserCart", callPolicy{attempts: 2}, []error{unavailable, unavailable}, false, -1, 2, true},
+		{"not retryable", "getUserCart", callPolicy{attempts: 3}, []error{status.Error(codes.InvalidArgument, "bad")}, false, 10, 1, true},
+		{"non-idempotent call", "chargeCard", callPolicy{attempts: 3}, []error{unavailable}, false, 10, 1, true},
+		{"timeout retried", "quoteShipping", callPolicy{attempts: 2, timeout: 20 * time.Millisecond}, nil, true, 10, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.policy.backoff = backoff{initial: time.Millisecond, max: time.Millisecond}
+			cs := &checkoutService{callPolicies: map[string]callPolicy{callDependencies[tt.call]: tt.policy}}
+			retries := downstreamRetries.get(tt.call)
+
+			ctx := context.Background()
+			if tt.budget >= 0 {
+				ctx = withRetryBudget(ctx, tt.budget)
+			}
+			calls := 0
+			start := time.Now()
+			err := cs.callWithPolicy(ctx, tt.call, func(ctx context.Context) error {
+				calls++
+				if tt.block {
+					<-ctx.Done()
+					return ctx.Err()
+				}
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("callWithPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("fn called %d times, want %d", calls, tt.wantCalls)
+			}
+			if got := downstreamRetries.get(tt.call) - retries; got != uint64(tt.wantCalls-1) {
+				t.Errorf("counted %d retries, want %d", got, tt.wantCalls-1)
+			}
+			if tt.block && time.Since(start) > time.Second {
+				t.Errorf("timed out attempts took %v, want about %v", time.Since(start), 2*tt.policy.timeout)
+			}
+		})
+	}
+}
+
+func TestCallWithPolicyStopsWhenRequestEnds(t *testing.T) {
+	cs := &checkoutService{callPolicies: map[string]callPolicy{"cart": {attempts: 5, backoff: backoff{initial: time.Hour, max: time.Hour}}}}
+	ctx, cancel := context.WithCancel(withRetryBudget(context.Background(), 10))
+	calls := 0
+	err := cs.callWithPolicy(ctx, "getUserCart", func(context.Context) error {
+		calls++
+		cancel()
+		return status.Error(codes.Unavailable, "restarting")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("callWithPolicy() = %v after %d calls, want an error after 1", err, calls)
+	}
+}
+
+func TestCallPoliciesFromEnv(t *testing.T) {
+	t.Setenv("CALL_TIMEOUT", "3s")
+	t.Setenv("CALL_ATTEMPTS", "2")
+	t.Setenv("PAYMENT_CALL_TIMEOUT", "10s")
+	t.Setenv("EMAIL_CALL_ATTEMPTS", "4")
+	got := callPoliciesFromEnv()
+	if p := got["cart"]; p.timeout != 3*time.Second || p.attempts != 2 || p.backoff != defaultCallBackoff {
+		t.Errorf("cart policy = %+v, want the CALL_* settings", p)
+	}
+	if p := got["payment"]; p.timeout != 10*time.Second || p.attempts != 2 {
+		t.Errorf("payment policy = %+v, want a 10s timeout and 2 attempts", p)
+	}
+	if p := got["email"]; p.attempts != 4 {
+		t.Errorf("email policy = %+v, want 4 attempts", p)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+)
+
+// connPool spreads calls round-robin over several connections to the same
+// service, so that one HTTP/2 connection doesn't become the bottleneck.
+type connPool struct {
+	conns []*grpc.ClientConn
+	next  atomic.Uint64
+}
+
+// dialPool dials size connections to addr.
+func dialPool(ctx context.Context, addr string, size int, cfg dialConfig) (*connPool, error) {
+	p := &connPool{conns: make([]*grpc.ClientConn, 0, size)}
+	for i := 0; i < size; i++ {
+		conn, err := dialGRPC(ctx, addr, cfg)
+		if err != nil {
+			p.close()
+			return nil, err
+		}
+		p.conns = append(p.conns, conn)
+	}
+	return p, nil
+}
+
+// pick returns the connection to use for the next call.
+func (p *connPool) pick() *grpc.ClientConn {
+	n := p.next.Add(1) - 1
+	return p.conns[n%uint64(len(p.conns))]
+}
+
+func (p *connPool) close() {
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+	money "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/money"
+)
+
+func TestCurrencyPoolSpreadsConversions(t *testing.T) {
+	const poolSize, calls = 3, 9
+	var (
+		fakes []*fakeDownstreams
+		pool  connPool
+	)
+	for i := 0; i < poolSize; i++ {
+		f := newFakeDownstreams()
+		f.rates = map[string]pb.Money{"EUR": {CurrencyCode: "EUR", Nanos: 900000000}}
+		fakes = append(fakes, f)
+		pool.conns = append(pool.conns, serveBufconn(t, func(s *grpc.Server) { pb.RegisterCurrencyServiceServer(s, f) }))
+	}
+	cs := &checkoutService{currencySvcConn: pool.conns[0], currencyPool: &pool}
+
+	want := pb.Money{CurrencyCode: "EUR", Units: 9}
+	for i := 0; i < calls; i++ {
+		got, err := cs.convertCurrency(context.Background(), &pb.Money{CurrencyCode: "USD", Units: 10}, "EUR")
+		if err != nil {
+			t.Fatalf("convertCurrency() error = %v", err)
+		}
+		if !money.AreEquals(*got, want) {
+			t.Errorf("convertCurrency() = %v, want %v", got, money.Format(want))
+		}
+	}
+	for i, f := range fakes {
+		if f.conversions != calls/poolSize {
+			t.Errorf("connection %d served %d conversions, want %d", i, f.conversions, calls/poolSize)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+	money "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/money"
+)
+
+const (
+	// promoCodeHeader is the request metadata key carrying a promo code.
+	promoCodeHeader = "promo-code"
+	// promoDiscountHeader is the response metadata key reporting the
+	// discount granted by a promo code.
+	promoDiscountHeader = "promo-discount"
+)
+
+// promotion is the discount granted by a promo code: either Percent off the
+// order total or a fixed Amount, which is converted to the user currency.
+// A zero Expires means the code never expires.
+type promotion struct {
+	Percent float64   `json:"percent,omitempty"`
+	Amount  *pb.Money `json:"amount,omitempty"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+func (p promotion) validate() error {
+	switch {
+	case p.Percent != 0 && p.Amount != nil:
+		return fmt.Errorf("sets both percent and amount")
+	case p.Percent != 0:
+		if p.Percent < 0 || p.Percent > 100 {
+			return fmt.Errorf("percent %v is not between 0 and 100", p.Percent)
+		}
+	case p.Amount != nil:
+		if !money.IsValid(*p.Amount) || !money.IsPositive(*p.Amount) || p.Amount.GetCurrencyCode() == "" {
+			return fmt.Errorf("amount %v is not a positive amount of money", p.Amount)
+		}
+	default:
+		return fmt.Errorf("sets neither percent nor amount")
+	}
+	return nil
+}
+
+// promotionsFromEnv parses PROMO_CODES, a JSON object mapping promo codes to
+// promotions, e.g. {"SAVE10": {"percent": 10}}. Codes are case-insensitive.
+func promotionsFromEnv() map[string]promotion {
+	raw := stringFromEnv("PROMO_CODES", "")
+	if raw == "" {
+		return nil
+	}
+	var parsed map[string]promotion
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+		panic(fmt.Sprintf("environment variable \"PROMO_CODES\" is not valid JSON: %v", err))
+	}
+	promos := make(map[string]promotion, len(parsed))
+	for code, p := range parsed {
+		if err := p.validate(); err != nil {
+			panic(fmt.Sprintf("promo code %q in \"PROMO_CODES\" %v", code, err))
+		}
+		promos[normalizePromoCode(code)] = p
+	}
+	return promos
+}
+
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func promoCode(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if v := md.Get(promoCodeHeader); len(v) > 0 {
+		return normalizePromoCode(v[0])
+	}
+	return ""
+}
+
+// applyPromotion returns total reduced by the discount of code, never below
+// zero. Unknown, expired or unusable codes are ignored with a warning so
+// that they don't fail the order.
+func (cs *checkoutService) applyPromotion(ctx context.Context, code string, total pb.Money) pb.Money {
+	promo, ok := cs.promotions[code]
+	if !ok {
+		log.Warnf("ignoring unknown promo code %q", code)
+		return total
+	}
+	if !promo.Expires.IsZero() && !time.Now().Before(promo.Expires) {
+		log.Warnf("ignoring promo code %q that expired at %v", code, promo.Expires)
+		return total
+	}
+
+	var discount pb.Money
+	if promo.Amount != nil {
+		converted, err := cs.convertCurrency(ctx, promo.Amount, total.GetCurrencyCode())
+		if err != nil {
+			log.Warnf("ignoring promo code %q: %v", code, err)
+			return total
+		}
+		discount = *converted
+	} else {
+		nanos := int64(math.Round(promo.Percent * 1e7))
+		rate := pb.Money{CurrencyCode: total.GetCurrencyCode(), Units: nanos / 1e9, Nanos: int32(nanos % 1e9)}
+		d, err := money.MultiplyRate(total, rate)
+		if err != nil {
+			log.Warnf("ignoring promo code %q: %v", code, err)
+			return total
+		}
+		discount = d
+	}
+
+	discounted, err := money.Subtract(total, discount)
+	if err != nil {
+		log.Warnf("ignoring promo code %q: %v", code, err)
+		return total
+	}
+	if money.IsNegative(discounted) {
+		discount, discounted = total, pb.Money{CurrencyCode: total.GetCurrencyCode()}
+	}
+	log.Infof("promo code %q took %s off the order total", code, money.Format(discount))
+	_ = grpc.SetHeader(ctx, metadata.Pairs(promoDiscountHeader, money.Format(discount)))
+	return discounted
+}
@@ -0,0 +1,90 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+	money "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/money"
+)
+
+func TestPlaceOrderPromoCodes(t *testing.T) {
+	promos := map[string]promotion{
+		"SAVE10":  {Percent: 10},
+		"FIVEOFF": {Amount: &pb.Money{CurrencyCode: "USD", Units: 5}},
+		"ALLFREE": {Amount: &pb.Money{CurrencyCode: "USD", Units: 1000}},
+		"FIFTY4":  {Amount: &pb.Money{CurrencyCode: "USD", Units: 54}},
+		"OLD":     {Percent: 50, Expires: time.Now().Add(-time.Hour)},
+	}
+	tests := []struct {
+		name string
+		code string
+		want pb.Money
+	}{
+		{"none", "", pb.Money{CurrencyCode: "USD", Units: 54, Nanos: 470000000}},
+		{"percentage", "save10", pb.Money{CurrencyCode: "USD", Units: 49, Nanos: 23000000}},
+		{"fixed", "FIVEOFF", pb.Money{CurrencyCode: "USD", Units: 49, Nanos: 470000000}},
+		{"fixed leaving less than a unit", "FIFTY4", pb.Money{CurrencyCode: "USD", Nanos: 470000000}},
+		{"fixed above total", "ALLFREE", pb.Money{CurrencyCode: "USD"}},
+		{"unknown", "BOGUS", pb.Money{CurrencyCode: "USD", Units: 54, Nanos: 470000000}},
+		{"expired", "OLD", pb.Money{CurrencyCode: "USD", Units: 54, Nanos: 470000000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeDownstreams()
+			cs := newTestCheckoutService(t, f)
+			cs.promotions = promos
+
+			ctx := context.Background()
+			if tt.code != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(promoCodeHeader, tt.code))
+			}
+			if _, err := cs.PlaceOrder(ctx, testPlaceOrderRequest()); err != nil {
+				t.Fatalf("PlaceOrder() error = %v", err)
+			}
+			if len(f.charged) != 1 || !money.AreEquals(*f.charged[0], tt.want) {
+				t.Errorf("charged %v, want %v", f.charged, money.Format(tt.want))
+			}
+		})
+	}
+}
+
+func TestPromotionValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		p       promotion
+		wantErr bool
+	}{
+		{"percent", promotion{Percent: 15}, false},
+		{"amount", promotion{Amount: &pb.Money{CurrencyCode: "EUR", Units: 2}}, false},
+		{"empty", promotion{}, true},
+		{"both", promotion{Percent: 5, Amount: &pb.Money{CurrencyCode: "EUR", Units: 2}}, true},
+		{"percent above 100", promotion{Percent: 120}, true},
+		{"negative amount", promotion{Amount: &pb.Money{CurrencyCode: "EUR", Units: -2}}, true},
+		{"amount without currency", promotion{Amount: &pb.Money{Units: 2}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.p.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
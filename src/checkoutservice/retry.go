@@ -0,0 +1,71 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// maxAttemptsPerCall is the default number of tries of a call, the
+	// first included.
+	maxAttemptsPerCall = 3
+	retryBaseDelay     = 25 * time.Millisecond
+)
+
+// retryBudget is a pool of retries shared by every downstream call made on
+// behalf of one request, so a broadly degraded cluster sees a bounded number
+// of extra calls rather than a retry storm.
+type retryBudget struct {
+	mu     sync.Mutex
+	tokens int
+}
+
+// take consumes a token, reporting false once the budget is exhausted.
+func (b *retryBudget) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens <= 0 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+type ctxKeyRetryBudget struct{}
+
+// withRetryBudget allows up to n retries by callWithPolicy across all calls
+// made with the returned context.
+func withRetryBudget(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, ctxKeyRetryBudget{}, &retryBudget{tokens: n})
+}
+
+func retryBudgetFrom(ctx context.Context) *retryBudget {
+	b, _ := ctx.Value(ctxKeyRetryBudget{}).(*retryBudget)
+	return b
+}
+
+func isRetryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+)
+
+func TestRetryBudgetExhaustion(t *testing.T) {
+	f := newFakeDownstreams()
+	f.convertErr = status.Error(codes.Unavailable, "currency service overloaded")
+	cs := newTestCheckoutService(t, f)
+	ctx := withRetryBudget(context.Background(), maxAttemptsPerCall)
+	usd := &pb.Money{CurrencyCode: "USD", Units: 1}
+
+	// The first call retries up to the per-call limit, the second uses the
+	// last token, and from then on calls fail without retrying.
+	want := []int{maxAttemptsPerCall, 2, 1, 1}
+	for i, attempts := range want {
+		before := f.conversions
+		if _, err := cs.convertCurrency(ctx, usd, "EUR"); err == nil {
+			t.Fatalf("call %d: convertCurrency() succeeded, want error", i)
+		}
+		if got := f.conversions - before; got != attempts {
+			t.Errorf("call %d: %d attempts, want %d", i, got, attempts)
+		}
+	}
+}
+
+func TestRetryRecoversTransientFailure(t *testing.T) {
+	f := newFakeDownstreams()
+	f.getCartErr = status.Error(codes.Unavailable, "cart service restarting")
+	cs := newTestCheckoutService(t, f)
+	cs.retryBudget = 1
+	f.onGetCart = func() { f.getCartErr = nil }
+
+	if _, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest()); err != nil {
+		t.Fatalf("PlaceOrder() error = %v, want success after one retry", err)
+	}
+}
+
+func TestNoRetryForCharge(t *testing.T) {
+	f := newFakeDownstreams()
+	f.chargeErr = status.Error(codes.Unavailable, "payment service restarting")
+	cs := newTestCheckoutService(t, f)
+	cs.retryBudget = 10
+
+	if _, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest()); err == nil {
+		t.Fatal("PlaceOrder() succeeded, want payment error")
+	}
+	if f.chargeCalls != 1 {
+		t.Errorf("Charge called %d times, want 1", f.chargeCalls)
+	}
+}
+
+func TestRetryWithoutBudget(t *testing.T) {
+	f := newFakeDownstreams()
+	f.convertErr = status.Error(codes.Unavailable, "down")
+	f.productErr = status.Error(codes.Unavailable, "down")
+	cs := newTestCheckoutService(t, f)
+
+	cs.convertCurrency(context.Background(), &pb.Money{CurrencyCode: "USD", Units: 1}, "EUR")
+	if f.conversions != maxAttemptsPerCall {
+		t.Errorf("Convert without a budget attempted %d times, want %d", f.conversions, maxAttemptsPerCall)
+	}
+	cs.getProductsBatch(context.Background(), []string{"OLJCESPC7Z"})
+	if f.getCalls != maxAttemptsPerCall {
+		t.Errorf("GetProduct without a budget attempted %d times, want %d", f.getCalls, maxAttemptsPerCall)
+	}
+}
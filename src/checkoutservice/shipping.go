@@ -0,0 +1,120 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+)
+
+const (
+	// shippingMethodHeader is the request metadata key selecting a shipping
+	// method such as "express".
+	shippingMethodHeader = "shipping-method"
+	// standardShipping is the default shipping method. It has no surcharge.
+	standardShipping = "standard"
+)
+
+// shippingSurchargesFromEnv parses SHIPPING_METHOD_SURCHARGES, a comma
+// separated list of method=multiplier pairs such as "express=1.5". The
+// multiplier is applied to the shipping quote of orders using the method.
+func shippingSurchargesFromEnv() map[string]float64 {
+	out := make(map[string]float64)
+	for _, kv := range listFromEnv("SHIPPING_METHOD_SURCHARGES") {
+		method, v, ok := strings.Cut(kv, "=")
+		m, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if !ok || err != nil || m < 1 {
+			panic(fmt.Sprintf("environment variable \"SHIPPING_METHOD_SURCHARGES\" has invalid entry %q, want method=multiplier with a multiplier of at least 1", kv))
+		}
+		out[strings.ToLower(strings.TrimSpace(method))] = m
+	}
+	return out
+}
+
+func shippingMethod(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(shippingMethodHeader); len(v) > 0 && v[0] != "" {
+			return strings.ToLower(strings.TrimSpace(v[0]))
+		}
+	}
+	return standardShipping
+}
+
+// shippingSurcharge returns the rate the shipping quote is multiplied by for
+// method. It reports false for unknown methods.
+func (cs *checkoutService) shippingSurcharge(method string) (pb.Money, bool) {
+	m, ok := cs.shippingSurcharges[method]
+	if !ok {
+		if method != standardShipping {
+			return pb.Money{}, false
+		}
+		m = 1
+	}
+	nanos := int64(math.Round(m * 1e9))
+	return pb.Money{Units: nanos / 1e9, Nanos: int32(nanos % 1e9)}, true
+}
+
+// shippingCountrySet builds the set of supported shipping countries from
+// their names. It returns nil, allowing every country, when names is empty.
+func shippingCountrySet(names []string) map[string]bool {
+	if len(names) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(names))
+	for _, n := range names {
+		set[normalizeCountry(n)] = true
+	}
+	return set
+}
+
+func normalizeCountry(name string) string { return strings.ToLower(strings.TrimSpace(name)) }
+
+// shipsTo reports whether orders can be shipped to address.
+func (cs *checkoutService) shipsTo(address *pb.Address) bool {
+	return cs.shippingCountries == nil || cs.shippingCountries[normalizeCountry(address.GetCountry())]
+}
+
+// shippingQuoteKey identifies a shipping quote by destination and cart
+// contents. Address fields are normalized and items sorted so that the same
+// order always maps to the same key.
+func shippingQuoteKey(address *pb.Address, items []*pb.CartItem) string {
+	norm := func(s string) string { return strings.ToLower(strings.TrimSpace(s)) }
+
+	lines := make([]string, len(items))
+	for i, it := range items {
+		lines[i] = fmt.Sprintf("%s:%d", it.GetProductId(), it.GetQuantity())
+	}
+	sort.Strings(lines)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n%d\n",
+		norm(address.GetStreetAddress()),
+		norm(address.GetCity()),
+		norm(address.GetState()),
+		norm(address.GetCountry()),
+		address.GetZipCode())
+	fmt.Fprint(h, strings.Join(lines, ","))
+	return hex.EncodeToString(h.Sum(nil))
+}
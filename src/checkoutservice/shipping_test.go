@@ -0,0 +1,155 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+	money "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/money"
+)
+
+func TestQuoteShippingCache(t *testing.T) {
+	f := newFakeDownstreams()
+	cs := newTestCheckoutService(t, f)
+	now := time.Unix(0, 0)
+	cs.shippingQuotes = newTTLCache[string, pb.Money](time.Minute)
+	cs.shippingQuotes.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	addr := testPlaceOrderRequest().Address
+	quote := func(a *pb.Address, items []*pb.CartItem) {
+		t.Helper()
+		got, err := cs.quoteShipping(ctx, a, items)
+		if err != nil {
+			t.Fatalf("quoteShipping() error = %v", err)
+		}
+		if got.GetUnits() != f.quoteUSD.GetUnits() || got.GetNanos() != f.quoteUSD.GetNanos() {
+			t.Errorf("quoteShipping() = %v, want %v", got, f.quoteUSD)
+		}
+	}
+
+	quote(addr, f.cart)
+	// Same destination with reordered items and different casing is a hit.
+	reordered := []*pb.CartItem{f.cart[1], f.cart[0]}
+	sameAddr := *addr
+	sameAddr.City = "  MOUNTAIN VIEW "
+	quote(&sameAddr, reordered)
+	if f.quoteCalls != 1 {
+		t.Errorf("GetQuote calls after cache hit = %d, want 1", f.quoteCalls)
+	}
+
+	otherAddr := *addr
+	otherAddr.ZipCode = 10001
+	quote(&otherAddr, f.cart)
+	if f.quoteCalls != 2 {
+		t.Errorf("GetQuote calls after address change = %d, want 2", f.quoteCalls)
+	}
+
+	now = now.Add(time.Minute)
+	quote(addr, f.cart)
+	if f.quoteCalls != 3 {
+		t.Errorf("GetQuote calls after TTL expiry = %d, want 3", f.quoteCalls)
+	}
+}
+
+func TestQuoteShippingWithoutCache(t *testing.T) {
+	f := newFakeDownstreams()
+	cs := newTestCheckoutService(t, f)
+	for i := 0; i < 2; i++ {
+		if _, err := cs.quoteShipping(context.Background(), testPlaceOrderRequest().Address, f.cart); err != nil {
+			t.Fatalf("quoteShipping() error = %v", err)
+		}
+	}
+	if f.quoteCalls != 2 {
+		t.Errorf("GetQuote calls = %d, want 2", f.quoteCalls)
+	}
+}
+
+func TestPlaceOrderShippingCountries(t *testing.T) {
+	tests := []struct {
+		name      string
+		supported []string
+		country   string
+		wantCode  codes.Code
+	}{
+		{"no allowlist", nil, "Narnia", codes.OK},
+		{"supported", []string{"Canada", " united states "}, "United States", codes.OK},
+		{"unsupported", []string{"Canada", "United States"}, "France", codes.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeDownstreams()
+			cs := newTestCheckoutService(t, f)
+			cs.shippingCountries = shippingCountrySet(tt.supported)
+
+			req := testPlaceOrderRequest()
+			req.Address.Country = tt.country
+			_, err := cs.PlaceOrder(context.Background(), req)
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("PlaceOrder() code = %v, want %v (err: %v)", got, tt.wantCode, err)
+			}
+			if tt.wantCode != codes.OK && f.quoteCalls != 0 {
+				t.Errorf("GetQuote called %d times for an unsupported country, want 0", f.quoteCalls)
+			}
+		})
+	}
+}
+
+func TestPlaceOrderShippingMethod(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		wantCode codes.Code
+		wantCost pb.Money
+	}{
+		{"default", "", codes.OK, pb.Money{CurrencyCode: "USD", Units: 8, Nanos: 990000000}},
+		{"standard", "standard", codes.OK, pb.Money{CurrencyCode: "USD", Units: 8, Nanos: 990000000}},
+		{"express", "Express", codes.OK, pb.Money{CurrencyCode: "USD", Units: 13, Nanos: 485000000}},
+		{"unknown", "teleport", codes.InvalidArgument, pb.Money{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeDownstreams()
+			cs := newTestCheckoutService(t, f)
+			cs.shippingSurcharges = map[string]float64{"express": 1.5}
+
+			ctx := context.Background()
+			if tt.method != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(shippingMethodHeader, tt.method))
+			}
+			resp, err := cs.PlaceOrder(ctx, testPlaceOrderRequest())
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("PlaceOrder() code = %v, want %v (err: %v)", got, tt.wantCode, err)
+			}
+			if err != nil {
+				return
+			}
+			if got := *resp.GetOrder().GetShippingCost(); !money.AreEquals(got, tt.wantCost) {
+				t.Errorf("shipping cost = %s, want %s", money.Format(got), money.Format(tt.wantCost))
+			}
+			wantTotal := money.Must(money.Sum(pb.Money{CurrencyCode: "USD", Units: 45, Nanos: 480000000}, tt.wantCost))
+			if len(f.charged) != 1 || !money.AreEquals(*f.charged[0], wantTotal) {
+				t.Errorf("charged %v, want %s", f.charged, money.Format(wantTotal))
+			}
+		})
+	}
+}
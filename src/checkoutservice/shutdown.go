@@ -0,0 +1,65 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// inFlightRequests counts the RPCs currently being handled by the server, so
+// that a forced shutdown can report how many it aborted.
+type inFlightRequests struct {
+	n atomic.Int64
+}
+
+func (f *inFlightRequests) count() int64 { return f.n.Load() }
+
+func (f *inFlightRequests) unaryInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	f.n.Add(1)
+	defer f.n.Add(-1)
+	return handler(ctx, req)
+}
+
+func (f *inFlightRequests) streamInterceptor(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	f.n.Add(1)
+	defer f.n.Add(-1)
+	return handler(srv, ss)
+}
+
+// gracefulStop stops srv from accepting new RPCs and waits up to grace for
+// in-flight ones to finish, then force-stops the server. It returns the
+// number of RPCs that were still running when the server was force-stopped.
+func gracefulStop(srv *grpc.Server, grace time.Duration, inFlight *inFlightRequests) int64 {
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Info("server drained")
+		return 0
+	case <-time.After(grace):
+	}
+	aborted := inFlight.count()
+	log.Warnf("shutdown grace period of %v expired, aborting %d in-flight requests", grace, aborted)
+	srv.Stop()
+	<-done
+	return aborted
+}
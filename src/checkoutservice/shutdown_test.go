@@ -0,0 +1,89 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// blockingHealth holds every Check until its RPC is cancelled.
+type blockingHealth struct {
+	healthpb.UnimplementedHealthServer
+	started chan struct{}
+}
+
+func (b *blockingHealth) Check(ctx context.Context, _ *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	b.started <- struct{}{}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestGracefulStop(t *testing.T) {
+	tests := []struct {
+		name        string
+		inFlight    int
+		grace       time.Duration
+		wantAborted int64
+	}{
+		{"idle", 0, 5 * time.Second, 0},
+		{"grace period expires", 2, 200 * time.Millisecond, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inFlight inFlightRequests
+			health := &blockingHealth{started: make(chan struct{}, tt.inFlight)}
+			lis := bufconn.Listen(1024 * 1024)
+			srv := grpc.NewServer(grpc.UnaryInterceptor(inFlight.unaryInterceptor))
+			healthpb.RegisterHealthServer(srv, health)
+			go srv.Serve(lis)
+
+			conn, err := grpc.DialContext(context.Background(), "bufnet",
+				grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+					return lis.DialContext(ctx)
+				}),
+				grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatalf("failed to dial bufnet: %v", err)
+			}
+			defer conn.Close()
+			for i := 0; i < tt.inFlight; i++ {
+				go healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+				<-health.started
+			}
+
+			start := time.Now()
+			aborted := gracefulStop(srv, tt.grace, &inFlight)
+			elapsed := time.Since(start)
+
+			if aborted != tt.wantAborted {
+				t.Errorf("gracefulStop() aborted %d requests, want %d", aborted, tt.wantAborted)
+			}
+			if elapsed > tt.grace+time.Second {
+				t.Errorf("gracefulStop() took %v, want at most the %v grace period", elapsed, tt.grace)
+			}
+			if tt.inFlight > 0 && elapsed < tt.grace {
+				t.Errorf("gracefulStop() returned after %v, before the %v grace period", elapsed, tt.grace)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+)
+
+// stepTimings accumulates the time spent in each checkout phase of one
+// PlaceOrder, for the slow order warning.
+type stepTimings struct {
+	mu    sync.Mutex
+	steps map[string]time.Duration
+}
+
+type ctxKeyStepTimings struct{}
+
+func withStepTimings(ctx context.Context, t *stepTimings) context.Context {
+	return context.WithValue(ctx, ctxKeyStepTimings{}, t)
+}
+
+func stepTimingsFrom(ctx context.Context) *stepTimings {
+	t, _ := ctx.Value(ctxKeyStepTimings{}).(*stepTimings)
+	return t
+}
+
+func (t *stepTimings) add(name string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.steps == nil {
+		t.steps = make(map[string]time.Duration)
+	}
+	t.steps[name] += d
+}
+
+// fields returns the total time of every phase, formatted for logging.
+func (t *stepTimings) fields() map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make(map[string]string, len(t.steps))
+	for name, d := range t.steps {
+		out[name] = d.String()
+	}
+	return out
+}
+
+// timedSpan adds its duration to a stepTimings when it ends.
+type timedSpan struct {
+	trace.Span
+	timings *stepTimings
+	name    string
+	start   time.Time
+}
+
+func (s timedSpan) End(options ...trace.SpanEndOption) {
+	s.timings.add(s.name, time.Since(s.start))
+	s.Span.End(options...)
+}
+
+// warnIfSlow logs a warning with the per-phase breakdown when a PlaceOrder
+// that started at start took longer than cs.slowOrderThreshold.
+func (cs *checkoutService) warnIfSlow(start time.Time, steps *stepTimings, req *pb.PlaceOrderRequest, resp *pb.PlaceOrderResponse) {
+	elapsed := time.Since(start)
+	if elapsed <= cs.slowOrderThreshold {
+		return
+	}
+	log.WithFields(logrus.Fields{
+		"order_id": resp.GetOrder().GetOrderId(),
+		"user_id":  req.GetUserId(),
+		"duration": elapsed.String(),
+		"steps":    steps.fields(),
+	}).Warn("slow PlaceOrder")
+}
@@ -0,0 +1,77 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlaceOrderSlowOrderWarning(t *testing.T) {
+	logs := captureLogs(t)
+	f := newFakeDownstreams()
+	f.emailDelay = 50 * time.Millisecond
+	cs := newTestCheckoutService(t, f)
+	cs.slowOrderThreshold = 20 * time.Millisecond
+
+	resp, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest())
+	if err != nil {
+		t.Fatalf("PlaceOrder() error = %v", err)
+	}
+
+	var warning struct {
+		Msg      string            `json:"message"`
+		OrderID  string            `json:"order_id"`
+		Duration string            `json:"duration"`
+		Steps    map[string]string `json:"steps"`
+	}
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, "slow PlaceOrder") {
+			if err := json.Unmarshal([]byte(line), &warning); err != nil {
+				t.Fatalf("invalid log line %q: %v", line, err)
+			}
+		}
+	}
+	if warning.Msg == "" {
+		t.Fatalf("no slow order warning logged:\n%s", logs)
+	}
+	if warning.OrderID != resp.GetOrder().GetOrderId() || warning.Duration == "" {
+		t.Errorf("warning = %+v, want order id %q and a duration", warning, resp.GetOrder().GetOrderId())
+	}
+	email, err := time.ParseDuration(warning.Steps["sendOrderConfirmation"])
+	if err != nil || email < f.emailDelay {
+		t.Errorf("sendOrderConfirmation step = %q, want at least %v", warning.Steps["sendOrderConfirmation"], f.emailDelay)
+	}
+	if _, ok := warning.Steps["chargeCard"]; !ok {
+		t.Errorf("steps = %v, want chargeCard among them", warning.Steps)
+	}
+}
+
+func TestPlaceOrderFastOrderNoWarning(t *testing.T) {
+	logs := captureLogs(t)
+	f := newFakeDownstreams()
+	cs := newTestCheckoutService(t, f)
+	cs.slowOrderThreshold = time.Minute
+
+	if _, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest()); err != nil {
+		t.Fatalf("PlaceOrder() error = %v", err)
+	}
+	if strings.Contains(logs.String(), "slow PlaceOrder") {
+		t.Errorf("slow order warning logged for a fast order:\n%s", logs)
+	}
+}
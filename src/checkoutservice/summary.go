@@ -0,0 +1,84 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+	money "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/money"
+)
+
+// orderSummary is the money rollup of an order result.
+type orderSummary struct {
+	// lines holds cost x quantity for each order item, in order.
+	lines         []pb.Money
+	itemsSubtotal pb.Money
+	shipping      pb.Money
+	total         pb.Money
+}
+
+// summarizeOrder computes the line subtotals and totals of order. All
+// amounts must share the shipping cost's currency.
+func summarizeOrder(order *pb.OrderResult) (orderSummary, error) {
+	var out orderSummary
+	if order.GetShippingCost() == nil {
+		return out, fmt.Errorf("order has no shipping cost")
+	}
+	out.shipping = *order.GetShippingCost()
+	out.itemsSubtotal = pb.Money{CurrencyCode: out.shipping.GetCurrencyCode()}
+	out.lines = make([]pb.Money, len(order.GetItems()))
+	for i, it := range order.GetItems() {
+		if it.GetCost() == nil {
+			return out, fmt.Errorf("item %q has no cost", it.GetItem().GetProductId())
+		}
+		out.lines[i] = money.MultiplySlow(*it.GetCost(), uint32(it.GetItem().GetQuantity()))
+		sum, err := money.Sum(out.itemsSubtotal, out.lines[i])
+		if err != nil {
+			return out, fmt.Errorf("item %q: %w", it.GetItem().GetProductId(), err)
+		}
+		out.itemsSubtotal = sum
+	}
+	total, err := money.Sum(out.itemsSubtotal, out.shipping)
+	if err != nil {
+		return out, fmt.Errorf("shipping cost: %w", err)
+	}
+	out.total = total
+	return out, nil
+}
+
+// roundOrderForDisplay returns a copy of order with the item costs and the
+// shipping cost rounded to the minor unit of their currency. order is not
+// modified.
+func roundOrderForDisplay(order *pb.OrderResult, mode money.RoundingMode) *pb.OrderResult {
+	round := func(m *pb.Money) *pb.Money {
+		if m == nil {
+			return nil
+		}
+		r := money.Round(*m, mode)
+		return &r
+	}
+	out := &pb.OrderResult{
+		OrderId:            order.GetOrderId(),
+		ShippingTrackingId: order.GetShippingTrackingId(),
+		ShippingCost:       round(order.GetShippingCost()),
+		ShippingAddress:    order.GetShippingAddress(),
+		Items:              make([]*pb.OrderItem, len(order.GetItems())),
+	}
+	for i, it := range order.GetItems() {
+		out.Items[i] = &pb.OrderItem{Item: it.GetItem(), Cost: round(it.GetCost())}
+	}
+	return out
+}
@@ -0,0 +1,101 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+	money "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/money"
+)
+
+func testOrderItems(currency string) []*pb.OrderItem {
+	return []*pb.OrderItem{
+		{Item: &pb.CartItem{ProductId: "A", Quantity: 3}, Cost: &pb.Money{CurrencyCode: currency, Units: 19, Nanos: 990000000}},
+		{Item: &pb.CartItem{ProductId: "B", Quantity: 1}, Cost: &pb.Money{CurrencyCode: currency, Units: 5, Nanos: 500000000}},
+	}
+}
+
+func TestSummarizeOrderMatchesOrderTotal(t *testing.T) {
+	shipping := &pb.Money{CurrencyCode: "EUR", Units: 8, Nanos: 990000000}
+	items := testOrderItems("EUR")
+
+	got, err := summarizeOrder(&pb.OrderResult{Items: items, ShippingCost: shipping})
+	if err != nil {
+		t.Fatalf("summarizeOrder() error = %v", err)
+	}
+	want := new(checkoutService).orderTotal("order-1", "EUR", orderPrep{orderItems: items, shippingCostLocalized: shipping})
+	if !money.AreEquals(got.total, want) {
+		t.Errorf("summary total = %v, want %v", got.total, want)
+	}
+	if len(got.lines) != 2 || got.lines[0].GetUnits() != 59 || got.lines[0].GetNanos() != 970000000 {
+		t.Errorf("line subtotals = %v, want first line EUR 59.97", got.lines)
+	}
+	if got.itemsSubtotal.GetUnits() != 65 || got.itemsSubtotal.GetNanos() != 470000000 {
+		t.Errorf("items subtotal = %v, want EUR 65.47", got.itemsSubtotal)
+	}
+}
+
+func TestSummarizeOrderCurrencyMismatch(t *testing.T) {
+	items := testOrderItems("EUR")
+	items[1].Cost.CurrencyCode = "USD"
+	_, err := summarizeOrder(&pb.OrderResult{Items: items, ShippingCost: &pb.Money{CurrencyCode: "EUR", Units: 1}})
+	if !errors.Is(err, money.ErrMismatchingCurrency) {
+		t.Errorf("summarizeOrder() error = %v, want %v", err, money.ErrMismatchingCurrency)
+	}
+}
+
+func TestPlaceOrderEmailPricesRounded(t *testing.T) {
+	f := newFakeDownstreams()
+	f.rates = map[string]pb.Money{"EUR": {CurrencyCode: "EUR", Nanos: 913000000}}
+	cs := newTestCheckoutService(t, f)
+	cs.roundEmailPrices, cs.emailRounding = true, money.RoundHalfUp
+
+	req := testPlaceOrderRequest()
+	req.UserCurrency = "EUR"
+	resp, err := cs.PlaceOrder(context.Background(), req)
+	if err != nil {
+		t.Fatalf("PlaceOrder() error = %v", err)
+	}
+	if len(f.emails) != 1 || len(f.charged) != 1 {
+		t.Fatalf("sent %d emails and charged %d times, want 1 and 1", len(f.emails), len(f.charged))
+	}
+
+	// 19.99, 5.50 and 8.99 USD convert to 18.25087, 5.0215 and 8.20787 EUR.
+	emailed := f.emails[0].GetOrder()
+	want := []pb.Money{
+		{CurrencyCode: "EUR", Units: 18, Nanos: 250000000},
+		{CurrencyCode: "EUR", Units: 5, Nanos: 20000000},
+	}
+	for i, it := range emailed.GetItems() {
+		if !money.AreEquals(*it.GetCost(), want[i]) {
+			t.Errorf("emailed cost of %s = %s, want %s", it.GetItem().GetProductId(), money.Format(*it.GetCost()), money.Format(want[i]))
+		}
+	}
+	if wantShipping := (pb.Money{CurrencyCode: "EUR", Units: 8, Nanos: 210000000}); !money.AreEquals(*emailed.GetShippingCost(), wantShipping) {
+		t.Errorf("emailed shipping = %s, want %s", money.Format(*emailed.GetShippingCost()), money.Format(wantShipping))
+	}
+
+	// The charge and the returned order keep full precision.
+	wantCharged := pb.Money{CurrencyCode: "EUR", Units: 49, Nanos: 731110000}
+	if !money.AreEquals(*f.charged[0], wantCharged) {
+		t.Errorf("charged %s, want %s", money.Format(*f.charged[0]), money.Format(wantCharged))
+	}
+	if got := resp.GetOrder().GetItems()[0].GetCost(); got.GetNanos() != 250870000 {
+		t.Errorf("returned cost = %s, want it unrounded", money.Format(*got))
+	}
+}
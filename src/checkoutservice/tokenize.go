@@ -0,0 +1,94 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
+)
+
+const tokenizationTimeout = 5 * time.Second
+
+type tokenizeRequest struct {
+	Number          string `json:"number"`
+	CVV             int32  `json:"cvv"`
+	ExpirationYear  int32  `json:"expiration_year"`
+	ExpirationMonth int32  `json:"expiration_month"`
+}
+
+type tokenizeResponse struct {
+	Token string `json:"token"`
+}
+
+// cardTokenizer exchanges card details for an opaque token at a tokenization
+// endpoint.
+type cardTokenizer struct {
+	endpoint string
+	client   *http.Client
+}
+
+func newCardTokenizer(endpoint string) *cardTokenizer {
+	return &cardTokenizer{
+		endpoint: endpoint,
+		client:   newExternalHTTPClient(tokenizationTimeout),
+	}
+}
+
+// tokenize returns a card carrying the token in place of the card number and
+// no CVV. The payment service must accept such tokens. Errors never include
+// the card details.
+func (t *cardTokenizer) tokenize(ctx context.Context, card *pb.CreditCardInfo) (*pb.CreditCardInfo, error) {
+	body, err := json.Marshal(tokenizeRequest{
+		Number:          card.GetCreditCardNumber(),
+		CVV:             card.GetCreditCardCvv(),
+		ExpirationYear:  card.GetCreditCardExpirationYear(),
+		ExpirationMonth: card.GetCreditCardExpirationMonth(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var out tokenizeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("invalid tokenization response: %v", err)
+	}
+	if out.Token == "" {
+		return nil, errors.New("tokenization response has no token")
+	}
+	return &pb.CreditCardInfo{
+		CreditCardNumber:          out.Token,
+		CreditCardExpirationYear:  card.GetCreditCardExpirationYear(),
+		CreditCardExpirationMonth: card.GetCreditCardExpirationMonth(),
+	}, nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testPAN = "4432-8015-6152-0454"
+
+func TestPlaceOrderTokenizesCard(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req tokenizeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Number != testPAN {
+			t.Errorf("tokenization request = %+v, %v, want the card number", req, err)
+		}
+		writeJSON(w, http.StatusOK, tokenizeResponse{Token: "tok_123"})
+	}))
+	defer srv.Close()
+
+	f := newFakeDownstreams()
+	cs := newTestCheckoutService(t, f)
+	cs.tokenizer = newCardTokenizer(srv.URL)
+	logs := captureLogs(t)
+
+	if _, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest()); err != nil {
+		t.Fatalf("PlaceOrder() error = %v", err)
+	}
+	if len(f.cards) != 1 || f.cards[0].GetCreditCardNumber() != "tok_123" || f.cards[0].GetCreditCardCvv() != 0 {
+		t.Errorf("charged cards = %v, want only the token", f.cards)
+	}
+	if strings.Contains(logs.String(), testPAN) {
+		t.Errorf("card number found in logs:\n%s", logs)
+	}
+}
+
+func TestPlaceOrderPassesCardThroughWithoutTokenizer(t *testing.T) {
+	f := newFakeDownstreams()
+	cs := newTestCheckoutService(t, f)
+	logs := captureLogs(t)
+
+	if _, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest()); err != nil {
+		t.Fatalf("PlaceOrder() error = %v", err)
+	}
+	if len(f.cards) != 1 || f.cards[0].GetCreditCardNumber() != testPAN {
+		t.Errorf("charged cards = %v, want the card as sent", f.cards)
+	}
+	if strings.Contains(logs.String(), testPAN) {
+		t.Errorf("card number found in logs:\n%s", logs)
+	}
+}
+
+func TestPlaceOrderTokenizationFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	f := newFakeDownstreams()
+	cs := newTestCheckoutService(t, f)
+	cs.tokenizer = newCardTokenizer(srv.URL)
+
+	_, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest())
+	if status.Code(err) != codes.Unavailable || strings.Contains(err.Error(), testPAN) {
+		t.Errorf("PlaceOrder() error = %v, want Unavailable without the card number", err)
+	}
+	if f.chargeCalls != 0 {
+		t.Errorf("Charge called %d times after tokenization failed, want 0", f.chargeCalls)
+	}
+}
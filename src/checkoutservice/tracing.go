@@ -0,0 +1,113 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice"
+
+// startSpan starts a span named after a checkout phase as a child of the
+// span in ctx, so that traces group downstream calls by phase. The phase's
+// duration is also added to the stepTimings in ctx, if any.
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, name)
+	if t := stepTimingsFrom(ctx); t != nil {
+		return ctx, timedSpan{Span: span, timings: t, name: name, start: time.Now()}
+	}
+	return ctx, span
+}
+
+// endSpan records err on span, if not nil, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	span.End()
+}
+
+// backoff describes an exponential backoff between retries.
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+}
+
+func (b backoff) next(d time.Duration) time.Duration {
+	if d == 0 {
+		return b.initial
+	}
+	if d *= 2; d > b.max {
+		return b.max
+	}
+	return d
+}
+
+var traceExporterBackoff = backoff{initial: time.Second, max: time.Minute}
+
+// exportRetry retries failed span exports for a bounded time so a collector
+// restart doesn't lose batches, without holding on to them indefinitely.
+var exportRetry = otlptracegrpc.RetryConfig{
+	Enabled:         true,
+	InitialInterval: time.Second,
+	MaxInterval:     10 * time.Second,
+	MaxElapsedTime:  time.Minute,
+}
+
+// registerTraceExporter connects to the collector at addr, each attempt
+// bounded by dialTimeout, retrying with backoff until it is reachable, then
+// registers a batching exporter on tp. It returns early only if ctx is done.
+func registerTraceExporter(ctx context.Context, tp *sdktrace.TracerProvider, addr string, dialTimeout time.Duration, b backoff) {
+	var wait time.Duration
+	for {
+		exporter, err := newTraceExporter(ctx, addr, dialTimeout)
+		if err == nil {
+			tp.RegisterSpanProcessor(sdktrace.NewBatchSpanProcessor(exporter))
+			log.Infof("trace exporter connected to %s", addr)
+			return
+		}
+		wait = b.next(wait)
+		log.Warnf("failed to create trace exporter, retrying in %v: %v", wait, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func newTraceExporter(ctx context.Context, addr string, dialTimeout time.Duration) (*otlptrace.Exporter, error) {
+	conn, err := dialGRPC(ctx, addr, dialConfig{timeout: dialTimeout, block: true})
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := otlptracegrpc.New(ctx,
+		otlptracegrpc.WithGRPCConn(conn),
+		otlptracegrpc.WithRetry(exportRetry))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return exporter, nil
+}
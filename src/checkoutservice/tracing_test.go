@@ -0,0 +1,196 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeCollector struct {
+	collectorpb.UnimplementedTraceServiceServer
+
+	mu    sync.Mutex
+	spans int
+}
+
+func (c *fakeCollector) Export(_ context.Context, req *collectorpb.ExportTraceServiceRequest) (*collectorpb.ExportTraceServiceResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.GetResourceSpans() {
+		for _, ss := range rs.GetScopeSpans() {
+			c.spans += len(ss.GetSpans())
+		}
+	}
+	return &collectorpb.ExportTraceServiceResponse{}, nil
+}
+
+func (c *fakeCollector) received() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.spans
+}
+
+func TestRegisterTraceExporterWaitsForCollector(t *testing.T) {
+	// Reserve an address, but only start the collector on it later.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample()))
+	defer tp.Shutdown(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	registered := make(chan struct{})
+	go func() {
+		registerTraceExporter(ctx, tp, addr, 100*time.Millisecond, backoff{initial: 50 * time.Millisecond, max: 100 * time.Millisecond})
+		close(registered)
+	}()
+
+	// Spans ended while the collector is down are dropped without blocking.
+	_, span := tp.Tracer("test").Start(context.Background(), "dropped")
+	span.End()
+
+	time.Sleep(300 * time.Millisecond)
+	collector := new(fakeCollector)
+	lis, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("could not listen on reserved address %s: %v", addr, err)
+	}
+	srv := grpc.NewServer()
+	collectorpb.RegisterTraceServiceServer(srv, collector)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	select {
+	case <-registered:
+	case <-time.After(10 * time.Second):
+		t.Fatal("exporter was not registered after the collector came up")
+	}
+	_, span = tp.Tracer("test").Start(context.Background(), "exported")
+	span.End()
+	if err := tp.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("ForceFlush() error = %v", err)
+	}
+	if got := collector.received(); got != 1 {
+		t.Errorf("collector received %d spans, want 1", got)
+	}
+}
+
+func TestBackoffNext(t *testing.T) {
+	b := backoff{initial: time.Second, max: 5 * time.Second}
+	var d time.Duration
+	var got []time.Duration
+	for i := 0; i < 5; i++ {
+		d = b.next(d)
+		got = append(got, d)
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("backoff sequence = %v, want %v", got, want)
+		}
+	}
+}
+
+// recordSpans installs a global tracer provider recording every span for the
+// duration of the test.
+func recordSpans(t *testing.T) (*sdktrace.TracerProvider, *tracetest.SpanRecorder) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return tp, rec
+}
+
+func TestPlaceOrderPhaseSpans(t *testing.T) {
+	tp, rec := recordSpans(t)
+	f := newFakeDownstreams()
+	cs := newTestCheckoutService(t, f)
+
+	ctx, root := tp.Tracer("test").Start(context.Background(), "PlaceOrder")
+	req := testPlaceOrderRequest()
+	req.UserCurrency = "EUR"
+	if _, err := cs.PlaceOrder(ctx, req); err != nil {
+		t.Fatalf("PlaceOrder() error = %v", err)
+	}
+	root.End()
+
+	names := make(map[trace.SpanID]string)
+	for _, s := range rec.Ended() {
+		names[s.SpanContext().SpanID()] = s.Name()
+	}
+	got := make(map[string]int)
+	for _, s := range rec.Ended() {
+		if s.Name() != "PlaceOrder" {
+			got[names[s.Parent().SpanID()]+" > "+s.Name()]++
+		}
+	}
+	want := map[string]int{
+		"PlaceOrder > prepOrderItems":        1,
+		"prepOrderItems > convertCurrency":   2,
+		"PlaceOrder > quoteShipping":         1,
+		"PlaceOrder > convertCurrency":       1,
+		"PlaceOrder > chargeCard":            1,
+		"PlaceOrder > shipOrder":             1,
+		"PlaceOrder > sendOrderConfirmation": 1,
+	}
+	if len(got) != len(want) {
+		t.Errorf("spans = %v, want %v", got, want)
+	}
+	for k, n := range want {
+		if got[k] != n {
+			t.Errorf("%d %q spans, want %d", got[k], k, n)
+		}
+	}
+}
+
+func TestPlaceOrderSpanRecordsError(t *testing.T) {
+	_, rec := recordSpans(t)
+	f := newFakeDownstreams()
+	f.shipErr = status.Error(codes.Unavailable, "shipping is down")
+	cs := newTestCheckoutService(t, f)
+
+	if _, err := cs.PlaceOrder(context.Background(), testPlaceOrderRequest()); err == nil {
+		t.Fatal("PlaceOrder() succeeded, want a shipping failure")
+	}
+	for _, s := range rec.Ended() {
+		if s.Name() != "shipOrder" {
+			continue
+		}
+		if s.Status().Code != otelcodes.Error || len(s.Events()) == 0 {
+			t.Errorf("shipOrder span status = %v with %d events, want an error", s.Status(), len(s.Events()))
+		}
+		return
+	}
+	t.Error("no shipOrder span recorded")
+}